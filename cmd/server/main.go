@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ilukemagic/gogate/internal/config"
 	"github.com/ilukemagic/gogate/internal/handler"
 	"github.com/ilukemagic/gogate/internal/middleware"
+	"github.com/ilukemagic/gogate/internal/server"
 )
 
 func main() {
@@ -22,13 +23,44 @@ func main() {
 	}
 
 	// 创建 JWT 中间件
-	jwtMiddleware := middleware.NewJWTMiddleware(
-		cfg.JWT.SecretKey,
-		cfg.JWT.Exclude,
-	)
+	jwtMiddleware, err := middleware.NewJWTMiddleware(cfg.JWT)
+	if err != nil {
+		log.Fatal("Failed to create JWT middleware:", err)
+	}
+
+	// 创建认证中间件，按路由选择 JWT 或 API Key
+	authenticator, err := middleware.NewAuthenticator(cfg.Auth, jwtMiddleware)
+	if err != nil {
+		log.Fatal("Failed to create authenticator:", err)
+	}
+
+	// 创建用量配额，配额管理接口只允许具备管理角色的用户访问
+	quotaManager, err := middleware.NewQuotaManager(cfg.Quota)
+	if err != nil {
+		log.Fatal("Failed to create quota manager:", err)
+	}
+	if quotaManager.Enabled() {
+		if cfg.Authz.Routes == nil {
+			cfg.Authz.Routes = make(map[string][]config.AuthzRuleConfig)
+		}
+		adminPath := quotaManager.AdminPath()
+		cfg.Authz.Routes[adminPath] = append(cfg.Authz.Routes[adminPath], config.AuthzRuleConfig{Roles: cfg.Quota.AdminRoles})
+	}
+
+	// 创建授权中间件
+	authorizer := middleware.NewAuthorizer(cfg.Authz)
 
 	// 创建限流中间件
-	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit)
+	rateLimiter, err := middleware.NewRateLimiter(cfg.RateLimit)
+	if err != nil {
+		log.Fatal("Failed to create rate limiter:", err)
+	}
+
+	// 创建负载保护中间件
+	loadShedder, err := middleware.NewLoadShedder(cfg.LoadShed)
+	if err != nil {
+		log.Fatal("Failed to create load shedder:", err)
+	}
 
 	// 创建代理处理器
 	proxyHandler, err := handler.NewProxyHandler(cfg.Proxy.Routes)
@@ -39,60 +71,83 @@ func main() {
 	// 创建 gin 引擎实例
 	r := gin.Default()
 
-	// 健康检查接口
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
+	// 客户端 IP 用于限流与登录锁定，只接受可信代理转发的 X-Forwarded-For
+	if err := r.SetTrustedProxies(cfg.Proxy.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted proxies:", err)
+	}
+
+	// 创建网关服务，关闭时先发送 WebSocket 关闭帧
+	srv := server.New(cfg.Proxy, r)
+	srv.OnShutdown("websockets", func(ctx context.Context) error {
+		log.Printf("Closing %d websocket connections", proxyHandler.ActiveWebSockets())
+		return proxyHandler.CloseWebSockets(ctx)
+	})
+	srv.OnShutdown("jwks", func(ctx context.Context) error {
+		jwtMiddleware.Close()
+		authenticator.Close()
+		return nil
+	})
+	srv.OnShutdown("ratelimit", func(ctx context.Context) error {
+		return rateLimiter.Close()
+	})
+	srv.OnShutdown("quota", func(ctx context.Context) error {
+		return quotaManager.Close()
 	})
 
-	// 注册登录路由
-	r.POST("/api/auth/login", func(c *gin.Context) {
-		var login struct {
-			Username string `json:"username"`
-			Password string `json:"password"`
-		}
+	// 限流中间件，认证之前注册的路由同样经过限流，是否限流由 exempt 与 include 决定
+	rateLimit := rateLimiter.Handle()
 
-		if err := c.BindJSON(&login); err != nil {
-			c.JSON(400, gin.H{"error": "invalid request"})
-			return
-		}
+	// 负载保护中间件，在限流之前执行，过载时先丢弃低优先级的请求，健康检查不受影响
+	loadShed := loadShedder.Handle()
 
-		// todo: 这里简化了验证逻辑，实际应用中需要验证用户名和密码
-		token, err := jwtMiddleware.GenerateToken("123", login.Username)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "failed to generate token"})
+	// 健康检查接口，开始关闭后返回 503 以便负载均衡器摘除实例
+	r.GET("/health", func(c *gin.Context) {
+		if !srv.Healthy() {
+			c.JSON(503, gin.H{"status": "shutting down"})
 			return
 		}
-
-		c.JSON(200, gin.H{
-			"token": token,
-		})
+		c.JSON(200, gin.H{"status": "ok"})
 	})
 
-	// 应用限流中间件
-	r.Use(rateLimiter.Handle())
+	// 注册登录、刷新与登出路由，未配置凭证存储时不提供这些接口
+	if cfg.Auth.Credentials.Type != "" {
+		authHandler, err := handler.NewAuthHandler(cfg.Auth, jwtMiddleware)
+		if err != nil {
+			log.Fatal("Failed to create auth handler:", err)
+		}
+		r.POST("/api/auth/login", loadShed, rateLimit, authHandler.Login)
+		r.POST("/api/auth/refresh", loadShed, rateLimit, authHandler.Refresh)
+		r.POST("/api/auth/logout", loadShed, rateLimit, authHandler.Logout)
+	} else {
+		log.Println("No credential store configured, login endpoint disabled")
+	}
 
-	// 注册API路由处理中间件
-	r.Use(func(c *gin.Context) {
-		path := c.Request.URL.Path
+	// 注册 OIDC 登录回调与登出路由
+	if oidc := authenticator.OIDC(); oidc != nil {
+		r.GET(oidc.CallbackPath(), loadShed, rateLimit, oidc.HandleCallback)
+		r.GET(oidc.LogoutPath(), loadShed, rateLimit, oidc.HandleLogout)
+		r.POST(oidc.LogoutPath(), loadShed, rateLimit, oidc.HandleLogout)
+	}
 
-		// 只处理/api开头且不是/api/auth开头的路径
-		if strings.HasPrefix(path, "/api") && !strings.HasPrefix(path, "/api/auth") {
-			// 应用JWT中间件
-			jwtMiddleware.Handle()(c)
+	// 注册配额管理接口，经过认证、负载保护、限流与授权，不计入配额
+	if quotaManager.Enabled() {
+		admin := r.Group(quotaManager.AdminPath(), authenticator.Handle(), loadShed, rateLimit, authorizer.Handle())
+		admin.GET("/:consumer", quotaManager.HandleView)
+		admin.DELETE("/:consumer", quotaManager.HandleReset)
+	}
 
-			// 如果验证通过，继续处理
-			if !c.IsAborted() {
-				proxyHandler.Handle(c)
-			}
+	// 按路由选择认证方式，认证、负载保护、限流、授权与配额检查通过后转发请求
+	// 负载保护与限流在认证之后执行，以便按声明选择优先级、按用户或 API Key 计数
+	r.Use(authenticator.Handle(), loadShed, rateLimit, authorizer.Handle(), quotaManager.Handle(), func(c *gin.Context) {
+		proxyHandler.Handle(c)
 
-			// 无论如何都不继续后续的处理器
-			c.Abort()
-		}
+		// 不继续后续的处理器
+		c.Abort()
 	})
 
 	// 启动服务器
 	log.Printf("Starting server on %s\n", cfg.Proxy.Listen)
-	if err := r.Run(cfg.Proxy.Listen); err != nil {
-		log.Fatal("Failed to start server:", err)
+	if err := srv.Run(cfg.Shutdown); err != nil {
+		log.Fatal("Server stopped with error:", err)
 	}
 }
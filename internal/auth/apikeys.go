@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// API Key 对应的调用方
+type Consumer struct {
+	Name   string
+	Routes []string // 允许访问的路由前缀，为空时不限制
+	Tier   string   // 限流等级
+}
+
+// 是否允许访问该路径
+func (c *Consumer) Allows(path string) bool {
+	if len(c.Routes) == 0 {
+		return true
+	}
+	for _, route := range c.Routes {
+		if strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// API Key 存储，只保存 SHA-256 哈希
+// API Key 为高熵随机串，无需 bcrypt 这类慢哈希，每个请求只需计算一次 SHA-256
+type APIKeyStore struct {
+	keys map[[sha256.Size]byte]*Consumer
+}
+
+// 加载配置与文件中的 API Key
+func NewAPIKeyStore(cfg config.APIKeysConfig) (*APIKeyStore, error) {
+	entries := cfg.Keys
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		var fileEntries []config.APIKeyConfig
+		if err := yaml.Unmarshal(data, &fileEntries); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.File, err)
+		}
+		entries = append(entries[:len(entries):len(entries)], fileEntries...)
+	}
+
+	keys := make(map[[sha256.Size]byte]*Consumer)
+	for i, entry := range entries {
+		raw, err := hex.DecodeString(entry.Hash)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api key %d (%s): hash must be a hex-encoded SHA-256 digest", i, entry.Consumer)
+		}
+		if entry.Consumer == "" {
+			return nil, fmt.Errorf("api key %d: consumer is required", i)
+		}
+
+		var hash [sha256.Size]byte
+		copy(hash[:], raw)
+		if _, ok := keys[hash]; ok {
+			return nil, fmt.Errorf("api key %d (%s): duplicate hash", i, entry.Consumer)
+		}
+		keys[hash] = &Consumer{Name: entry.Consumer, Routes: entry.Routes, Tier: entry.Tier}
+	}
+
+	return &APIKeyStore{keys: keys}, nil
+}
+
+// 查找 API Key 对应的调用方
+func (s *APIKeyStore) Lookup(key string) (*Consumer, bool) {
+	consumer, ok := s.keys[sha256.Sum256([]byte(key))]
+	return consumer, ok
+}
+
+// 计算 API Key 的哈希，用于写入配置
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 用户名或密码错误
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// 通过验证的用户
+type User struct {
+	ID       string
+	Username string
+}
+
+// 凭证存储，验证用户名与密码
+// 凭证错误时返回 ErrInvalidCredentials，其余错误表示存储本身不可用
+type CredentialStore interface {
+	Verify(ctx context.Context, username, password string) (*User, error)
+}
+
+// 根据配置创建凭证存储
+func NewCredentialStore(cfg config.CredentialsConfig) (CredentialStore, error) {
+	switch cfg.Type {
+	case config.CredentialsHtpasswd:
+		return NewHtpasswdStore(cfg.File)
+	case config.CredentialsHTTP:
+		return NewHTTPStore(cfg.URL, cfg.Timeout)
+	default:
+		return nil, fmt.Errorf("unsupported credentials type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 基于 htpasswd 文件的凭证存储，只支持 bcrypt 哈希 (htpasswd -B)
+type HtpasswdStore struct {
+	users map[string]htpasswdEntry
+
+	// 用户不存在时仍执行一次开销相同的 bcrypt 比较，避免通过响应时间探测用户名
+	cost      int
+	dummyOnce sync.Once
+	dummyHash []byte
+}
+
+type htpasswdEntry struct {
+	hash []byte
+	id   string
+}
+
+// 加载 htpasswd 文件，每行格式为 username:bcrypt-hash[:userId]，userId 缺省时与用户名相同
+func NewHtpasswdStore(path string) (*HtpasswdStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]htpasswdEntry)
+	cost := bcrypt.DefaultCost
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%s:%d: invalid entry", path, lineNo)
+		}
+		entryCost, err := bcrypt.Cost([]byte(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported: %w", path, lineNo, err)
+		}
+		cost = entryCost
+
+		entry := htpasswdEntry{hash: []byte(parts[1]), id: parts[0]}
+		if len(parts) == 3 && parts[2] != "" {
+			entry.id = parts[2]
+		}
+		users[parts[0]] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &HtpasswdStore{users: users, cost: cost}, nil
+}
+
+// 验证用户名与密码
+func (s *HtpasswdStore) Verify(ctx context.Context, username, password string) (*User, error) {
+	entry, ok := s.users[username]
+	if !ok {
+		s.dummyOnce.Do(func() {
+			s.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), s.cost)
+		})
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword(entry.hash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &User{ID: entry.id, Username: username}, nil
+}
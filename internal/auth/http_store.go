@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 请求上游认证服务的默认超时
+const defaultHTTPStoreTimeout = 5 * time.Second
+
+// 将凭证验证委托给上游认证服务
+// 以 JSON 发送 {"username","password"}，2xx 表示验证通过并返回 {"userId","username"}，
+// 401/403 表示凭证错误，其余状态码视为认证服务不可用
+type HTTPStore struct {
+	url    string
+	client *http.Client
+}
+
+// 创建委托上游认证服务的凭证存储
+func NewHTTPStore(url string, timeout time.Duration) (*HTTPStore, error) {
+	if url == "" {
+		return nil, errors.New("credentials url is required")
+	}
+	if timeout <= 0 {
+		timeout = defaultHTTPStoreTimeout
+	}
+
+	return &HTTPStore{url: url, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// 验证用户名与密码
+func (s *HTTPStore) Verify(ctx context.Context, username, password string) (*User, error) {
+	body, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("auth service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, ErrInvalidCredentials
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("auth service: unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		UserID   string `json:"userId"`
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("auth service: decode response: %w", err)
+	}
+	if result.UserID == "" {
+		return nil, errors.New("auth service: response is missing userId")
+	}
+	if result.Username == "" {
+		result.Username = username
+	}
+
+	return &User{ID: result.UserID, Username: result.Username}, nil
+}
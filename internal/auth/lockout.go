@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// 超过该数量时清理过期的记录
+const lockoutPruneThreshold = 10000
+
+// 登录失败锁定
+// 连续失败达到阈值后锁定，之后每次失败锁定时长翻倍，直到最长锁定时长；
+// 距上次失败超过最长锁定时长后计数清零
+type Lockout struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// 创建登录失败锁定
+func NewLockout(maxAttempts int, baseDelay, maxDelay time.Duration) *Lockout {
+	return &Lockout{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		entries:     make(map[string]*lockoutEntry),
+	}
+}
+
+// 剩余锁定时间，未锁定时返回 0
+func (l *Lockout) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	if wait := time.Until(entry.lockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// 记录一次失败，返回此后的锁定时间
+func (l *Lockout) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := l.entries[key]
+	if !ok || l.expired(entry, now) {
+		if len(l.entries) >= lockoutPruneThreshold {
+			l.prune(now)
+		}
+		entry = &lockoutEntry{}
+		l.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures < l.maxAttempts {
+		return 0
+	}
+
+	// 达到阈值后按指数退避
+	delay := l.maxDelay
+	if shift := entry.failures - l.maxAttempts; shift < 32 {
+		if d := l.baseDelay << shift; d > 0 && d < l.maxDelay {
+			delay = d
+		}
+	}
+	entry.lockedUntil = now.Add(delay)
+	return delay
+}
+
+// 登录成功后清除失败记录
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// 记录是否已过期
+func (l *Lockout) expired(entry *lockoutEntry, now time.Time) bool {
+	return now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) > l.maxDelay
+}
+
+// 清理过期的记录
+func (l *Lockout) prune(now time.Time) {
+	for key, entry := range l.entries {
+		if l.expired(entry, now) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 两次清理过期记录的最小间隔
+const revocationPruneInterval = time.Minute
+
+// token 吊销存储，多副本部署时可替换为共享存储
+type RevocationStore interface {
+	// 吊销单个 token，记录保留到 token 过期；token 此前已被吊销时返回 false
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+	// 吊销用户在 before 及之前签发的所有 token，记录保留 ttl，此后这些 token 均已过期
+	RevokeUser(ctx context.Context, userID string, before time.Time, ttl time.Duration) error
+	// token 是否已被吊销
+	IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
+}
+
+// 基于内存的吊销存储，只在单个网关实例内生效
+type MemoryRevocationStore struct {
+	mu        sync.Mutex
+	tokens    map[string]time.Time // jti -> 过期时间
+	users     map[string]userRevocation
+	lastPrune time.Time
+}
+
+// 用户的吊销记录
+type userRevocation struct {
+	before    time.Time // 在此及之前签发的 token 均已吊销
+	expiresAt time.Time // 记录的过期时间
+}
+
+// 创建基于内存的吊销存储
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+	}
+}
+
+// 吊销单个 token
+func (s *MemoryRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+
+	if _, ok := s.tokens[jti]; ok {
+		return false, nil
+	}
+	s.tokens[jti] = expiresAt
+	return true, nil
+}
+
+// 吊销用户的所有 token
+func (s *MemoryRevocationStore) RevokeUser(ctx context.Context, userID string, before time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+
+	if prev, ok := s.users[userID]; !ok || before.After(prev.before) {
+		s.users[userID] = userRevocation{before: before, expiresAt: before.Add(ttl)}
+	}
+	return nil
+}
+
+// 清理过期的记录，调用方需持有锁
+func (s *MemoryRevocationStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) <= revocationPruneInterval {
+		return
+	}
+	for id, exp := range s.tokens {
+		if now.After(exp) {
+			delete(s.tokens, id)
+		}
+	}
+	for userID, revocation := range s.users {
+		if now.After(revocation.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+	s.lastPrune = now
+}
+
+// token 是否已被吊销
+func (s *MemoryRevocationStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jti != "" {
+		if _, ok := s.tokens[jti]; ok {
+			return true, nil
+		}
+	}
+	if revocation, ok := s.users[userID]; ok && !issuedAt.After(revocation.before) {
+		return true, nil
+	}
+	return false, nil
+}
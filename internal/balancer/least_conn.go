@@ -0,0 +1,93 @@
+package balancer
+
+import "sync"
+
+// 带连接计数的目标服务器
+type ConnTarget struct {
+	URL    string
+	Weight int
+	Active int // 当前活跃连接数
+}
+
+// 加权最少连接负载均衡器，按长连接数量而不是请求数量分配，适用于 WebSocket
+type LeastConnections struct {
+	targets []*ConnTarget
+	mu      sync.Mutex
+}
+
+// 创建加权最少连接负载均衡器
+func NewLeastConnections(targets map[string]int) *LeastConnections {
+	lc := &LeastConnections{}
+	lc.UpdateTargets(targets)
+	return lc
+}
+
+// 选择 活跃连接数/权重 最小的目标并占用一个连接，使用完毕后需调用 Release
+func (l *LeastConnections) Acquire() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var best *ConnTarget
+	for _, t := range l.targets {
+		// 比较 t.Active/t.Weight < best.Active/best.Weight，交叉相乘避免浮点运算
+		if best == nil || t.Active*best.Weight < best.Active*t.Weight {
+			best = t
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	best.Active++
+	return best.URL
+}
+
+// 释放目标上的一个连接
+func (l *LeastConnections) Release(url string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, t := range l.targets {
+		if t.URL == url && t.Active > 0 {
+			t.Active--
+			return
+		}
+	}
+}
+
+// 获取每个目标的活跃连接数
+func (l *LeastConnections) Active() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	active := make(map[string]int, len(l.targets))
+	for _, t := range l.targets {
+		active[t.URL] = t.Active
+	}
+	return active
+}
+
+// 更新目标服务器列表，保留仍然存在的目标的连接计数
+func (l *LeastConnections) UpdateTargets(targets map[string]int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous := make(map[string]int, len(l.targets))
+	for _, t := range l.targets {
+		previous[t.URL] = t.Active
+	}
+
+	l.targets = make([]*ConnTarget, 0, len(targets))
+	for url, weight := range targets {
+		// 权重至少为 1，避免除零
+		if weight <= 0 {
+			weight = 1
+		}
+		l.targets = append(l.targets, &ConnTarget{
+			URL:    url,
+			Weight: weight,
+			Active: previous[url],
+		})
+	}
+}
@@ -2,52 +2,377 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // 路由配置
 type RouteConfig struct {
-	Targets []TargetConfig `yaml:"targets"` // 支持多个目标服务器
+	Targets   []TargetConfig     `yaml:"targets"`   // 支持多个目标服务器
+	TLS       *UpstreamTLSConfig `yaml:"tls"`       // 上游 TLS 配置，对该路由下所有目标生效
+	HTTP2     bool               `yaml:"http2"`     // 使用 HTTP/2 连接上游，http:// 目标使用 h2c
+	Protocol  string             `yaml:"protocol"`  // 路由协议: http (默认) 或 grpc
+	WebSocket WebSocketConfig    `yaml:"websocket"` // WebSocket 连接配置
+
+	Concurrency ConcurrencyConfig `yaml:"concurrency"` // 自适应并发限制
+
+	Timeout       time.Duration `yaml:"timeout"`       // 请求总超时，0 表示不限制
+	IdleTimeout   time.Duration `yaml:"idleTimeout"`   // 上游响应空闲超时，超过该时间没有数据则断开，0 表示不限制
+	FlushInterval time.Duration `yaml:"flushInterval"` // 响应刷新间隔，负数表示每次写入后立即刷新
+	Streaming     bool          `yaml:"streaming"`     // 流式响应 (SSE 等)：立即刷新、禁用缓冲，且不受总超时限制
 }
 
+// WebSocket 连接配置
+type WebSocketConfig struct {
+	MaxConnections int           `yaml:"maxConnections"` // 最大并发连接数，0 表示不限制
+	IdleTimeout    time.Duration `yaml:"idleTimeout"`    // 双向都没有数据帧时关闭连接，0 表示不限制
+}
+
+// 自适应并发限制 (AIMD)：上游变慢或过载时按比例降低并发上限，恢复后逐步提高
+type ConcurrencyConfig struct {
+	Enable           bool          `yaml:"enable"`           // 是否启用
+	InitialLimit     int           `yaml:"initialLimit"`     // 初始并发上限，默认 20
+	MinLimit         int           `yaml:"minLimit"`         // 并发上限的最小值，默认 1
+	MaxLimit         int           `yaml:"maxLimit"`         // 并发上限的最大值，默认 200
+	LatencyThreshold time.Duration `yaml:"latencyThreshold"` // 上游响应头超过该时间视为过载，默认 1s
+	BackoffRatio     float64       `yaml:"backoffRatio"`     // 过载时并发上限乘以该比例，默认 0.9
+	QueueSize        int           `yaml:"queueSize"`        // 达到上限时最多排队的请求数，默认等于当前上限，-1 表示不排队
+	QueueTimeout     time.Duration `yaml:"queueTimeout"`     // 排队的最长时间，默认 50ms
+}
+
+// 路由协议
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
 // 目标服务器配置
 type TargetConfig struct {
-	URL    string `yaml:"url"`    // 服务器地址
-	Weight int    `yaml:"weight"` // 权重
+	URL    string             `yaml:"url"`    // 服务器地址
+	Weight int                `yaml:"weight"` // 权重
+	TLS    *UpstreamTLSConfig `yaml:"tls"`    // 目标级 TLS 配置，优先于路由级配置
+}
+
+// 上游 TLS 配置
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"caFile"`             // 自定义 CA 证书 (PEM)
+	CertFile           string `yaml:"certFile"`           // 客户端证书 (mTLS)
+	KeyFile            string `yaml:"keyFile"`            // 客户端私钥 (mTLS)
+	ServerName         string `yaml:"serverName"`         // 覆盖 SNI 及证书校验使用的主机名
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"` // 跳过证书校验，仅用于开发环境
 }
 
 // 代理配置
 type ProxyConfig struct {
-	Listen string                 `yaml:"listen"`
-	Routes map[string]RouteConfig `yaml:"routes"`
+	Listen  string                 `yaml:"listen"`
+	TLS     *ListenerTLSConfig     `yaml:"tls"`     // 监听端 TLS 配置，启用后支持 HTTP/2
+	H2C     bool                   `yaml:"h2c"`     // 允许明文 HTTP/2 (h2c)
+	PIDFile string                 `yaml:"pidFile"` // 进程号文件，热升级 (SIGUSR2) 后由新进程覆盖
+	Routes  map[string]RouteConfig `yaml:"routes"`
+
+	// 可信代理的 IP 或 CIDR，只有来自这些地址的请求才按 X-Forwarded-For 确定客户端 IP
+	// 默认不信任任何代理，客户端 IP 为连接的对端地址
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// 监听端 TLS 配置
+type ListenerTLSConfig struct {
+	CertFile string `yaml:"certFile"` // 服务端证书
+	KeyFile  string `yaml:"keyFile"`  // 服务端私钥
 }
 
 // JWT 配置
 type JWTConfig struct {
-	SecretKey string   `yaml:"secretKey"`
-	Exclude   []string `yaml:"exclude"` // 不需要验证的路径
+	SecretKey string `yaml:"secretKey"` // HS256/HS384/HS512 使用的共享密钥
+	// 允许的签名算法，例如 RS256、ES256、EdDSA，默认只允许 HS256
+	Algorithms []string       `yaml:"algorithms"`
+	Keys       []JWTKeyConfig `yaml:"keys"`    // 验证签名的公钥，密钥轮换期间可同时配置多个
+	JWKS       *JWKSConfig    `yaml:"jwks"`    // 从身份提供方的 JWKS 地址获取公钥
+	Exclude    []string       `yaml:"exclude"` // 不需要验证的路径
+
+	Issuer   string        `yaml:"issuer"`   // 期望的 iss，为空时不校验
+	Audience []string      `yaml:"audience"` // 可接受的 aud，token 中包含其一即可，为空时不校验
+	Leeway   time.Duration `yaml:"leeway"`   // 校验 exp/nbf 时允许的时钟偏差
+	// 必须存在的声明及其可接受的值，值列表为空时只要求声明存在，例如 tenant: [a, b]
+	RequiredClaims map[string][]string `yaml:"requiredClaims"`
+	// 特定路由的校验配置
+	Routes map[string]JWTRouteConfig `yaml:"routes"`
+	// 转发给上游的声明，请求头名 -> 声明路径 (嵌套声明用 . 分隔，例如 org.tenant)
+	// 客户端携带的同名请求头会被移除
+	ClaimHeaders map[string]string `yaml:"claimHeaders"`
+	// WebSocket 握手时浏览器无法设置 Authorization 头，可从该查询参数读取 token，默认 access_token
+	QueryParam string        `yaml:"queryParam"`
+	TokenTTL   time.Duration `yaml:"tokenTTL"`   // 网关签发的访问 token 有效期，默认 15m
+	RefreshTTL time.Duration `yaml:"refreshTTL"` // 网关签发的刷新 token 有效期，默认 168h
+}
+
+// 特定路由的 JWT 校验配置
+type JWTRouteConfig struct {
+	Audience []string `yaml:"audience"` // 覆盖全局的 audience
+}
+
+// JWT 验证公钥
+type JWTKeyConfig struct {
+	KID       string `yaml:"kid"`       // 与 token 头部的 kid 匹配
+	File      string `yaml:"file"`      // PEM 格式的公钥或证书
+	Algorithm string `yaml:"algorithm"` // 可选，限定该密钥只能用于某个算法
+}
+
+// JWKS 配置
+type JWKSConfig struct {
+	URL             string        `yaml:"url"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"` // 定时刷新间隔，默认 1h
+	// 遇到未知 kid 时触发刷新的最小间隔，也是获取失败后的重试间隔，默认 1m
+	MinRefreshInterval time.Duration `yaml:"minRefreshInterval"`
+	Timeout            time.Duration `yaml:"timeout"` // 请求超时，默认 5s
+}
+
+// 认证配置
+type AuthConfig struct {
+	Credentials CredentialsConfig `yaml:"credentials"`
+	Lockout     LockoutConfig     `yaml:"lockout"`
+	APIKeys     APIKeysConfig     `yaml:"apiKeys"`
+	// 通过授权服务器的自省端点校验不透明 token
+	Introspection *IntrospectionConfig `yaml:"introspection"`
+	// 浏览器通过 OpenID Connect 登录，会话在需要 JWT 的路由上等同于有效 token
+	OIDC *OIDCConfig `yaml:"oidc"`
+	// 路由前缀 -> 接受的认证方式 (jwt、apiKey、introspection、none)，按最长前缀匹配，未匹配的路由使用 jwt
+	// 未配置时只有 /api 下的路由使用 jwt，/api/auth 与其他路由无需认证
+	Routes map[string][]string `yaml:"routes"`
+}
+
+// 认证方式
+const (
+	AuthMethodJWT           = "jwt"
+	AuthMethodAPIKey        = "apiKey"
+	AuthMethodIntrospection = "introspection" // 不透明 token，通过自省端点校验
+	AuthMethodNone          = "none"          // 不需要认证
+)
+
+// 令牌自省配置 (RFC 7662)
+type IntrospectionConfig struct {
+	URL string `yaml:"url"` // 自省端点
+	// 调用自省端点的客户端凭证，以 HTTP Basic 发送
+	ClientID     string        `yaml:"clientId"`
+	ClientSecret string        `yaml:"clientSecret"`
+	Timeout      time.Duration `yaml:"timeout"` // 请求超时，默认 5s
+	// 有效 token 的结果缓存到 exp，该值限制最长缓存时间，0 表示不限制 (响应没有 exp 时缓存 1m)
+	MaxCacheTTL      time.Duration `yaml:"maxCacheTTL"`
+	InactiveCacheTTL time.Duration `yaml:"inactiveCacheTTL"` // 无效 token 的结果缓存时间，默认 1m
+}
+
+// API Key 配置
+type APIKeysConfig struct {
+	Header     string         `yaml:"header"`     // 读取 API Key 的请求头，默认 X-API-Key
+	QueryParam string         `yaml:"queryParam"` // 读取 API Key 的查询参数，为空时只从请求头读取
+	File       string         `yaml:"file"`       // 存放 API Key 的 YAML 文件，内容为 APIKeyConfig 列表
+	Keys       []APIKeyConfig `yaml:"keys"`
+}
+
+// API Key 及其元数据
+type APIKeyConfig struct {
+	Hash     string   `yaml:"hash"`     // API Key 的 SHA-256 哈希 (十六进制)，例如 echo -n KEY | sha256sum
+	Consumer string   `yaml:"consumer"` // 调用方名称
+	Routes   []string `yaml:"routes"`   // 允许访问的路由前缀，为空时不限制
+	Tier     string   `yaml:"tier"`     // 限流等级
+}
+
+// OpenID Connect 登录配置 (授权码模式 + PKCE)
+type OIDCConfig struct {
+	Issuer       string   `yaml:"issuer"` // 通过 {issuer}/.well-known/openid-configuration 发现端点
+	ClientID     string   `yaml:"clientId"`
+	ClientSecret string   `yaml:"clientSecret"` // 公共客户端可为空，仅依赖 PKCE
+	RedirectURL  string   `yaml:"redirectURL"`  // 回调地址，网关在其路径上处理回调
+	Scopes       []string `yaml:"scopes"`       // 默认 openid profile email
+	LogoutPath   string   `yaml:"logoutPath"`   // 清除会话的路径，默认 /auth/oidc/logout
+
+	CookieName     string        `yaml:"cookieName"`     // 会话 cookie 名称，默认 gogate_session
+	CookieSecret   string        `yaml:"cookieSecret"`   // 加密 cookie 的密钥，至少 32 个字符
+	InsecureCookie bool          `yaml:"insecureCookie"` // 不设置 cookie 的 Secure 属性，仅用于本地 http 开发
+	SessionTTL     time.Duration `yaml:"sessionTTL"`     // 会话有效期，默认 8h
+	Timeout        time.Duration `yaml:"timeout"`        // 请求身份提供方的超时，默认 5s
+}
+
+// 凭证存储类型
+const (
+	CredentialsHtpasswd = "htpasswd" // bcrypt 格式的 htpasswd 文件
+	CredentialsHTTP     = "http"     // 委托给上游认证服务
+)
+
+// 凭证存储配置，未配置时不提供登录接口
+type CredentialsConfig struct {
+	Type    string        `yaml:"type"`    // htpasswd 或 http
+	File    string        `yaml:"file"`    // htpasswd 文件，每行 username:bcrypt-hash[:userId]
+	URL     string        `yaml:"url"`     // 上游认证服务地址，POST {"username","password"}
+	Timeout time.Duration `yaml:"timeout"` // 请求上游认证服务的超时，默认 5s
+}
+
+// 登录失败锁定配置，按用户名与客户端 IP 分别计数
+type LockoutConfig struct {
+	MaxAttempts      int           `yaml:"maxAttempts"`      // 同一用户名连续失败多少次后锁定，默认 5
+	MaxAttemptsPerIP int           `yaml:"maxAttemptsPerIP"` // 同一 IP 连续失败多少次后锁定，默认 20
+	BaseDelay        time.Duration `yaml:"baseDelay"`        // 首次锁定时长，此后每次失败翻倍，默认 1s
+	MaxDelay         time.Duration `yaml:"maxDelay"`         // 最长锁定时长，默认 15m
+}
+
+// 授权配置
+type AuthzConfig struct {
+	ScopesClaim string `yaml:"scopesClaim"` // scope 所在的声明，默认 scope (空格分隔的字符串或数组)
+	RolesClaim  string `yaml:"rolesClaim"`  // 角色所在的声明，默认 roles
+	// 路由前缀 -> 授权规则，按最长前缀匹配，匹配到的规则需全部满足
+	Routes map[string][]AuthzRuleConfig `yaml:"routes"`
+}
+
+// 授权规则
+type AuthzRuleConfig struct {
+	Methods []string            `yaml:"methods"` // 适用的 HTTP 方法，为空时适用于所有方法
+	Scopes  []string            `yaml:"scopes"`  // 必须全部具备的 scope
+	Roles   []string            `yaml:"roles"`   // 具备其一即可
+	Claims  map[string][]string `yaml:"claims"`  // 声明路径 -> 可接受的值，例如 org.tenant: [a, b]
 }
 
 // 限流配置
 type RateLimitConfig struct {
-	Enable bool                            `yaml:"enable"` // 是否启用限流
-	Rate   int                             `yaml:"rate"`   // 每秒允许的请求数
-	Burst  int                             `yaml:"burst"`  // 突发流量的容量
-	Routes map[string]RateLimitRouteConfig `yaml:"routes"` // 特定路由的限流配置
+	Enable    bool          `yaml:"enable"`    // 是否启用限流
+	Algorithm string        `yaml:"algorithm"` // 限流算法，默认 tokenBucket
+	Rate      int           `yaml:"rate"`      // 每秒允许的请求数，用于 tokenBucket 与 gcra
+	Burst     int           `yaml:"burst"`     // 突发流量的容量，用于 tokenBucket 与 gcra
+	Limit     int           `yaml:"limit"`     // 每个窗口允许的请求数，用于窗口类算法
+	Window    time.Duration `yaml:"window"`    // 窗口长度，用于窗口类算法
+	// 按客户端分别限流的依据：ip、user、apiKey 或 header:<name>，为空时所有请求共享限额
+	// 无法取得 user、apiKey 或请求头时按客户端 IP 计数
+	Key     string                          `yaml:"key"`
+	MaxKeys int                             `yaml:"maxKeys"` // 最多跟踪的客户端数，超过后淘汰最久未使用的，默认 10000
+	Backend RateLimitBackendConfig          `yaml:"backend"` // 限流计数的存储
+	Routes  map[string]RateLimitRouteConfig `yaml:"routes"`  // 特定路由的限流配置
+	// 不限流的路径前缀，/health 始终不限流
+	Exempt []string `yaml:"exempt"`
+	// 只对这些路径前缀限流，为空时对所有路径限流；与 exempt 同时匹配时以较长的前缀为准
+	Include []string `yaml:"include"`
 }
 
-// 特定路由的限流配置
+// 按客户端限流的依据
+const (
+	RateLimitKeyIP           = "ip"
+	RateLimitKeyUser         = "user"    // JWT 的 subject，userId 声明或 sub
+	RateLimitKeyAPIKey       = "apiKey"  // API Key 对应的调用方
+	RateLimitKeyHeaderPrefix = "header:" // 指定请求头的值
+)
+
+// 限流算法
+const (
+	RateLimitAlgorithmTokenBucket          = "tokenBucket"          // 令牌桶，按 rate 补充令牌，最多 burst 个
+	RateLimitAlgorithmGCRA                 = "gcra"                 // 通用信元速率算法，请求按 1/rate 均匀间隔，最多提前 burst 个
+	RateLimitAlgorithmFixedWindow          = "fixedWindow"          // 固定窗口，窗口按 window 的整数倍对齐 (UTC)
+	RateLimitAlgorithmSlidingWindowLog     = "slidingWindowLog"     // 滑动窗口日志，记录窗口内每个请求的时间，精确但内存占用与 limit 成正比
+	RateLimitAlgorithmSlidingWindowCounter = "slidingWindowCounter" // 滑动窗口计数，按上一窗口的计数加权估算
+)
+
+// 特定路由的限流配置，algorithm 为空时使用 tokenBucket
 type RateLimitRouteConfig struct {
-	Rate  int `yaml:"rate"`  // 每秒允许的请求数
-	Burst int `yaml:"burst"` // 突发流量的容量
+	Algorithm string        `yaml:"algorithm"` // 限流算法
+	Rate      int           `yaml:"rate"`      // 每秒允许的请求数
+	Burst     int           `yaml:"burst"`     // 突发流量的容量
+	Limit     int           `yaml:"limit"`     // 每个窗口允许的请求数
+	Window    time.Duration `yaml:"window"`    // 窗口长度
+	Key       string        `yaml:"key"`       // 覆盖全局的 key
+}
+
+// 限流计数的存储
+type RateLimitBackendConfig struct {
+	Type  string      `yaml:"type"`  // memory 或 redis，默认 memory
+	Redis RedisConfig `yaml:"redis"` // type 为 redis 时的连接配置
+	// 共享存储不可用时改用本地限流，经过该时间后再尝试共享存储，默认 5s
+	RetryInterval time.Duration `yaml:"retryInterval"`
+}
+
+// 限流计数的存储类型
+const (
+	RateLimitBackendMemory = "memory" // 每个网关实例分别计数
+	RateLimitBackendRedis  = "redis"  // 所有网关实例通过 Redis 共享计数
+)
+
+// Redis 连接配置
+type RedisConfig struct {
+	Addr      string        `yaml:"addr"`      // 地址，如 localhost:6379
+	Username  string        `yaml:"username"`  // ACL 用户名
+	Password  string        `yaml:"password"`  // 密码
+	DB        int           `yaml:"db"`        // 数据库编号
+	KeyPrefix string        `yaml:"keyPrefix"` // key 前缀，默认 gogate:ratelimit:
+	Timeout   time.Duration `yaml:"timeout"`   // 连接与读写超时，默认 200ms
+}
+
+// 调用方用量配额，调用方为 API Key 对应的调用方或 JWT 的 subject（userId 声明，没有时使用 sub）
+// 周期按 UTC 计算，未认证的请求不计入配额
+type QuotaConfig struct {
+	Enable  bool  `yaml:"enable"`  // 是否启用配额
+	Daily   int64 `yaml:"daily"`   // 每天允许的请求数，0 表示不限制
+	Monthly int64 `yaml:"monthly"` // 每月允许的请求数，0 表示不限制
+	// 按 API Key 的 tier 覆盖默认配额
+	Tiers map[string]QuotaLimitConfig `yaml:"tiers"`
+	// 按调用方覆盖配额，如 apiKey:billing、user:u-1，优先于 tiers
+	Consumers  map[string]QuotaLimitConfig `yaml:"consumers"`
+	Store      QuotaStoreConfig            `yaml:"store"`      // 用量的存储
+	AdminPath  string                      `yaml:"adminPath"`  // 查看与重置配额的管理接口，默认 /admin/quotas
+	AdminRoles []string                    `yaml:"adminRoles"` // 访问管理接口需具备的角色之一
+}
+
+// 覆盖的配额，两项同时替换默认值，0 表示不限制
+type QuotaLimitConfig struct {
+	Daily   int64 `yaml:"daily"`
+	Monthly int64 `yaml:"monthly"`
+}
+
+// 配额用量的存储
+type QuotaStoreConfig struct {
+	Type          string        `yaml:"type"`          // memory 或 file，默认 memory
+	Path          string        `yaml:"path"`          // type 为 file 时的文件路径
+	FlushInterval time.Duration `yaml:"flushInterval"` // 写入文件的间隔，默认 10s，关闭时也会写入
+}
+
+// 配额用量的存储类型
+const (
+	QuotaStoreMemory = "memory"
+	QuotaStoreFile   = "file"
+)
+
+// 负载保护配置，网关并发请求过多时先丢弃低优先级的请求
+// 请求的优先级类别依次由路由、声明与请求头决定
+type LoadShedConfig struct {
+	Enable      bool `yaml:"enable"`      // 是否启用
+	MaxInFlight int  `yaml:"maxInFlight"` // 网关全局的并发请求上限
+	// 优先级从高到低的类别，为空时使用 critical、normal、low
+	Classes      []PriorityClassConfig `yaml:"classes"`
+	DefaultClass string                `yaml:"defaultClass"` // 未指定类别的请求使用的类别，默认 normal
+	// 路由前缀 -> 类别，按最长前缀匹配
+	Routes map[string]string `yaml:"routes"`
+	Claim  string            `yaml:"claim"`  // 指定类别的声明路径，如 priority，优先于路由
+	Header string            `yaml:"header"` // 指定类别的请求头，如 X-Priority，只能降低优先级
+}
+
+// 优先级类别
+type PriorityClassConfig struct {
+	Name  string  `yaml:"name"`
+	Share float64 `yaml:"share"` // 并发请求数低于 maxInFlight 的该比例时接受该类别的请求，取值 (0, 1]
+}
+
+// 优雅关闭配置
+type ShutdownConfig struct {
+	PreStopDelay time.Duration `yaml:"preStopDelay"` // 收到信号后 /health 先返回失败，等待该时间让负载均衡器摘除实例
+	DrainTimeout time.Duration `yaml:"drainTimeout"` // 等待进行中请求完成的最长时间，默认 30s
 }
 
 // 全局配置
 type Config struct {
 	Proxy     ProxyConfig     `yaml:"proxy"`
 	JWT       JWTConfig       `yaml:"jwt"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Authz     AuthzConfig     `yaml:"authz"`
 	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	Quota     QuotaConfig     `yaml:"quota"`
+	LoadShed  LoadShedConfig  `yaml:"loadShed"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown"`
 }
 
 func LoadConfig(path string) (*Config, error) {
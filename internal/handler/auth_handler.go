@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 登录失败锁定的默认参数
+const (
+	defaultMaxAttempts      = 5
+	defaultMaxAttemptsPerIP = 20
+	defaultLockoutBaseDelay = time.Second
+	defaultLockoutMaxDelay  = 15 * time.Minute
+)
+
+// 签发、刷新与吊销 token，由 JWTMiddleware 实现
+type TokenIssuer interface {
+	IssueTokens(userID, username string) (*middleware.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (*middleware.TokenPair, error)
+	Revoke(ctx context.Context, accessToken, refreshToken string, all bool) error
+}
+
+// 处理登录、刷新与登出请求
+type AuthHandler struct {
+	store       auth.CredentialStore
+	issuer      TokenIssuer
+	userLockout *auth.Lockout // 按用户名计数，防止针对单个账号的暴力破解
+	ipLockout   *auth.Lockout // 按客户端 IP 计数，防止同一来源尝试大量账号
+}
+
+// 创建登录处理器
+func NewAuthHandler(cfg config.AuthConfig, issuer TokenIssuer) (*AuthHandler, error) {
+	store, err := auth.NewCredentialStore(cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	lockout := cfg.Lockout
+	if lockout.MaxAttempts <= 0 {
+		lockout.MaxAttempts = defaultMaxAttempts
+	}
+	if lockout.MaxAttemptsPerIP <= 0 {
+		lockout.MaxAttemptsPerIP = defaultMaxAttemptsPerIP
+	}
+	if lockout.BaseDelay <= 0 {
+		lockout.BaseDelay = defaultLockoutBaseDelay
+	}
+	if lockout.MaxDelay <= 0 {
+		lockout.MaxDelay = defaultLockoutMaxDelay
+	}
+
+	return &AuthHandler{
+		store:       store,
+		issuer:      issuer,
+		userLockout: auth.NewLockout(lockout.MaxAttempts, lockout.BaseDelay, lockout.MaxDelay),
+		ipLockout:   auth.NewLockout(lockout.MaxAttemptsPerIP, lockout.BaseDelay, lockout.MaxDelay),
+	}, nil
+}
+
+// Login 验证用户名密码并签发 token
+func (h *AuthHandler) Login(c *gin.Context) {
+	var login struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := c.BindJSON(&login); err != nil || login.Username == "" || login.Password == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	userKey := "user:" + login.Username
+	ipKey := "ip:" + c.ClientIP()
+
+	// 锁定期间不验证密码
+	retryAfter := h.userLockout.RetryAfter(userKey)
+	if wait := h.ipLockout.RetryAfter(ipKey); wait > retryAfter {
+		retryAfter = wait
+	}
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(429, gin.H{"error": "too many failed login attempts", "code": "login_locked"})
+		return
+	}
+
+	user, err := h.store.Verify(c.Request.Context(), login.Username, login.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		h.userLockout.Fail(userKey)
+		h.ipLockout.Fail(ipKey)
+		c.JSON(401, gin.H{"error": "invalid username or password", "code": "invalid_credentials"})
+		return
+	}
+	if err != nil {
+		log.Printf("login: credential store error: %v", err)
+		c.JSON(503, gin.H{"error": "authentication service unavailable"})
+		return
+	}
+
+	// 成功登录只清除该用户名的计数，IP 计数不因持有一个有效账号而清零
+	h.userLockout.Reset(userKey)
+
+	tokens, err := h.issuer.IssueTokens(user.ID, user.Username)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	respondTokens(c, tokens)
+}
+
+// Refresh 使用刷新 token 换取新的访问 token 与刷新 token
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	if err := c.BindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	tokens, err := h.issuer.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		respondTokenError(c, err)
+		return
+	}
+
+	respondTokens(c, tokens)
+}
+
+// Logout 吊销请求携带的访问 token，以及请求体中可选的刷新 token
+// all 为 true 时吊销该用户此前签发的所有 token
+func (h *AuthHandler) Logout(c *gin.Context) {
+	accessToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || accessToken == "" {
+		c.JSON(401, gin.H{"error": "authorization header is required", "code": "token_missing"})
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+		All          bool   `json:"all"`
+	}
+	// 请求体可以为空
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if err := h.issuer.Revoke(c.Request.Context(), accessToken, req.RefreshToken, req.All); err != nil {
+		respondTokenError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+func respondTokens(c *gin.Context, tokens *middleware.TokenPair) {
+	c.JSON(200, gin.H{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    int(tokens.ExpiresIn.Seconds()),
+	})
+}
+
+func respondTokenError(c *gin.Context, err error) {
+	if errors.Is(err, middleware.ErrRevocationUnavailable) {
+		log.Printf("auth: revocation store error: %v", err)
+		c.JSON(503, gin.H{"error": "authentication service unavailable"})
+		return
+	}
+	code, message := middleware.TokenErrorCode(err)
+	c.JSON(401, gin.H{"error": message, "code": code})
+}
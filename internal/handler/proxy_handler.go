@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/grpcutil"
 	"github.com/ilukemagic/gogate/internal/proxy"
 )
 
@@ -18,19 +22,10 @@ func NewProxyHandler(routes map[string]config.RouteConfig) (*ProxyHandler, error
 	proxies := make(map[string]*proxy.ReverseProxy)
 
 	for path, route := range routes {
-		// 转换配置为权重映射
-		weightMap := make(map[string]int)
-		targets := make([]string, 0, len(route.Targets))
-
-		for _, target := range route.Targets {
-			weightMap[target.URL] = target.Weight
-			targets = append(targets, target.URL)
-		}
-
 		// 创建反向代理
-		p, err := proxy.NewReverseProxy(targets, weightMap)
+		p, err := proxy.NewReverseProxy(route)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("route %s: %w", path, err)
 		}
 		proxies[path] = p
 	}
@@ -56,9 +51,46 @@ func (h *ProxyHandler) Handle(c *gin.Context) {
 	}
 
 	if matchedProxy == nil {
+		if grpcutil.IsGRPCRequest(c.Request) {
+			grpcutil.WriteError(c.Writer, 404, "route not found")
+			return
+		}
 		c.JSON(404, gin.H{"error": "route not found"})
 		return
 	}
 
 	matchedProxy.ServeHTTP(c.Writer, c.Request)
 }
+
+// 当前所有路由的活跃 WebSocket 连接数
+func (h *ProxyHandler) ActiveWebSockets() int {
+	total := 0
+	for _, p := range h.proxies {
+		total += p.ActiveWebSockets()
+	}
+	return total
+}
+
+// 向所有 WebSocket 连接发送关闭帧并等待连接结束
+func (h *ProxyHandler) CloseWebSockets(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(h.proxies))
+
+	for _, p := range h.proxies {
+		wg.Add(1)
+		go func(p *proxy.ReverseProxy) {
+			defer wg.Done()
+			errs <- p.CloseWebSockets(ctx)
+		}(p)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// API Key 认证失败时响应中的错误码
+const (
+	codeAPIKeyMissing   = "api_key_missing"
+	codeInvalidAPIKey   = "invalid_api_key"
+	codeRouteNotAllowed = "route_not_allowed"
+)
+
+// 默认读取 API Key 的请求头
+const defaultAPIKeyHeader = "X-API-Key"
+
+// API Key 认证中间件，认证通过后将调用方 (*auth.Consumer) 以 consumer 存入上下文
+type APIKeyMiddleware struct {
+	store      *auth.APIKeyStore
+	header     string
+	queryParam string
+}
+
+// 创建 API Key 认证中间件
+func NewAPIKeyMiddleware(cfg config.APIKeysConfig) (*APIKeyMiddleware, error) {
+	store, err := auth.NewAPIKeyStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	header := cfg.Header
+	if header == "" {
+		header = defaultAPIKeyHeader
+	}
+
+	return &APIKeyMiddleware{
+		store:      store,
+		header:     http.CanonicalHeaderKey(header),
+		queryParam: cfg.QueryParam,
+	}, nil
+}
+
+// Gin 中间件处理函数
+func (m *APIKeyMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.authenticate(c) {
+			c.Next()
+		}
+	}
+}
+
+// 请求是否携带 API Key
+func (m *APIKeyMiddleware) present(r *http.Request) bool {
+	if r.Header.Get(m.header) != "" {
+		return true
+	}
+	return m.queryParam != "" && r.URL.Query().Get(m.queryParam) != ""
+}
+
+// 验证请求携带的 API Key，失败时中止请求并返回 false
+func (m *APIKeyMiddleware) authenticate(c *gin.Context) bool {
+	// 读取后移除，避免泄露给上游
+	key := c.Request.Header.Get(m.header)
+	c.Request.Header.Del(m.header)
+	if m.queryParam != "" {
+		if token := takeQueryToken(c.Request, m.queryParam); key == "" {
+			key = token
+		}
+	}
+
+	if key == "" {
+		abortWithCode(c, 401, codeAPIKeyMissing, "api key is required")
+		return false
+	}
+
+	consumer, ok := m.store.Lookup(key)
+	if !ok {
+		abortWithCode(c, 401, codeInvalidAPIKey, "invalid api key")
+		return false
+	}
+	if !consumer.Allows(c.Request.URL.Path) {
+		abortWithCode(c, 403, codeRouteNotAllowed, "api key is not allowed to access this route")
+		return false
+	}
+
+	c.Set("consumer", consumer)
+	return true
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 路由接受的认证方式
+type authMethods struct {
+	none          bool
+	jwt           bool
+	apiKey        bool
+	introspection bool
+}
+
+// 未配置的路由只接受 JWT
+var defaultAuthMethods = authMethods{jwt: true}
+
+// 未配置 auth.routes 时沿用网关原有的行为：只有 /api 下的路由需要 JWT，/api/auth 除外
+var defaultAuthRoutes = map[string][]string{
+	"/":         {config.AuthMethodNone},
+	"/api":      {config.AuthMethodJWT},
+	"/api/auth": {config.AuthMethodNone},
+}
+
+// 按路由选择认证方式的中间件
+type Authenticator struct {
+	jwt          *JWTMiddleware
+	apiKeys      *APIKeyMiddleware
+	introspector *Introspector // 未配置自省端点时为 nil
+	oidc         *OIDCProvider // 未配置 OIDC 登录时为 nil
+	routes       map[string]authMethods
+}
+
+// 创建认证中间件
+func NewAuthenticator(cfg config.AuthConfig, jwt *JWTMiddleware) (*Authenticator, error) {
+	apiKeys, err := NewAPIKeyMiddleware(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var introspector *Introspector
+	if cfg.Introspection != nil {
+		introspector, err = NewIntrospector(*cfg.Introspection)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var oidc *OIDCProvider
+	if cfg.OIDC != nil {
+		oidc, err = NewOIDCProvider(*cfg.OIDC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	routeCfg := cfg.Routes
+	if len(routeCfg) == 0 {
+		routeCfg = defaultAuthRoutes
+	}
+	routes := make(map[string]authMethods)
+	for route, names := range routeCfg {
+		var methods authMethods
+		for _, name := range names {
+			switch name {
+			case config.AuthMethodNone:
+				methods.none = true
+			case config.AuthMethodJWT:
+				methods.jwt = true
+			case config.AuthMethodAPIKey:
+				methods.apiKey = true
+			case config.AuthMethodIntrospection:
+				if introspector == nil {
+					return nil, fmt.Errorf("auth route %s: introspection is not configured", route)
+				}
+				methods.introspection = true
+			default:
+				return nil, fmt.Errorf("auth route %s: unknown method %q", route, name)
+			}
+		}
+		tokenMethods := methods.jwt || methods.introspection
+		if methods.none && (tokenMethods || methods.apiKey) {
+			return nil, fmt.Errorf("auth route %s: %q cannot be combined with other methods", route, config.AuthMethodNone)
+		}
+		// 空列表等同于 none
+		if !tokenMethods && !methods.apiKey {
+			methods.none = true
+		}
+		routes[route] = methods
+	}
+
+	return &Authenticator{
+		jwt:          jwt,
+		apiKeys:      apiKeys,
+		introspector: introspector,
+		oidc:         oidc,
+		routes:       routes,
+	}, nil
+}
+
+// OIDC 登录，未配置时返回 nil
+func (a *Authenticator) OIDC() *OIDCProvider {
+	return a.oidc
+}
+
+// 停止后台任务
+func (a *Authenticator) Close() {
+	if a.oidc != nil {
+		a.oidc.Close()
+	}
+}
+
+// Gin 中间件处理函数
+// 接受多种方式时，携带 API Key 的请求使用 API Key 认证；
+// 同时接受 JWT 与自省时，形如 JWT 的 token 在本地校验，其余 token 交给自省端点；
+// 配置 OIDC 登录后，接受 JWT 的路由同样接受会话 cookie，未登录的浏览器页面访问跳转登录
+func (a *Authenticator) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		methods := a.methodsFor(c.Request.URL.Path)
+
+		switch {
+		case methods.none:
+			// 未经认证的请求同样不能携带身份头
+			a.jwt.stripClaimHeaders(c)
+		case methods.apiKey && (!(methods.jwt || methods.introspection) || a.apiKeys.present(c.Request)):
+			if !a.apiKeys.authenticate(c) {
+				return
+			}
+			a.jwt.stripClaimHeaders(c)
+		case methods.jwt && a.oidc != nil && c.GetHeader("Authorization") == "" && !a.jwt.excluded(c.Request.URL.Path):
+			if !a.authenticateSession(c) {
+				return
+			}
+		case methods.introspection && !(methods.jwt && looksLikeJWT(c.Request)):
+			if !a.introspect(c) {
+				return
+			}
+		default:
+			if !a.jwt.authenticate(c) {
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// 按最长前缀匹配路由的认证方式
+func (a *Authenticator) methodsFor(path string) authMethods {
+	methods := defaultAuthMethods
+	var longestMatch string
+	for route, routeMethods := range a.routes {
+		if strings.HasPrefix(path, route) && len(route) > len(longestMatch) {
+			methods = routeMethods
+			longestMatch = route
+		}
+	}
+	return methods
+}
+
+// 通过自省端点校验不透明 token，失败时中止请求并返回 false
+func (a *Authenticator) introspect(c *gin.Context) bool {
+	token, err := a.jwt.extractToken(c)
+	if err != nil {
+		te := classifyTokenError(err)
+		abortWithCode(c, 401, te.code, te.message)
+		return false
+	}
+
+	claims, err := a.introspector.Introspect(c.Request.Context(), token)
+	if errors.Is(err, errIntrospectionUnavailable) {
+		log.Printf("Token introspection failed: %v", err)
+		abortWithCode(c, 503, codeIntrospectionUnavailable, "token introspection unavailable")
+		return false
+	}
+	if err == nil {
+		err = a.jwt.validateClaims(claims, c.Request.URL.Path)
+	}
+	if err != nil {
+		te := classifyTokenError(err)
+		abortWithCode(c, 401, te.code, te.message)
+		return false
+	}
+
+	// 与 JWT 认证相同的上下文键与声明请求头
+	a.jwt.setIdentity(c, claims)
+	return true
+}
+
+// 使用 OIDC 会话认证，没有会话时浏览器页面访问跳转登录，其余请求按 JWT 认证失败处理
+func (a *Authenticator) authenticateSession(c *gin.Context) bool {
+	claims := a.oidc.session(c.Request)
+	if claims == nil {
+		if isBrowserNavigation(c.Request) {
+			a.oidc.redirectToLogin(c)
+			return false
+		}
+		return a.jwt.authenticate(c)
+	}
+
+	// 会话与 token 同样需要满足必需声明且未被吊销
+	// 会话来自 ID token，其 aud 为 OIDC 客户端，不按路由的 audience 校验
+	err := a.jwt.validateRequiredClaims(claims)
+	if err == nil {
+		err = a.jwt.checkRevoked(c.Request.Context(), claims)
+	}
+	if err != nil {
+		te := classifyTokenError(err)
+		abortWithCode(c, 401, te.code, te.message)
+		return false
+	}
+
+	a.oidc.removeSessionCookie(c.Request)
+	a.jwt.setIdentity(c, claims)
+	return true
+}
+
+// Authorization 头中的 token 是否形如 JWT (三段以 . 分隔)
+func looksLikeJWT(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && strings.Count(token, ".") == 2
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 授权失败时响应中的错误码
+const (
+	codeInsufficientScope = "insufficient_scope"
+	codeMissingRole       = "missing_role"
+	codeClaimNotAllowed   = "claim_not_allowed"
+)
+
+// 授权规则
+type authzRule struct {
+	methods map[string]bool
+	scopes  []string
+	roles   []string
+	claims  map[string][]string // 声明路径 -> 可接受的值
+}
+
+// 路由级授权中间件，根据 JWTMiddleware 解析出的声明判断是否允许访问
+type Authorizer struct {
+	scopesClaim []string
+	rolesClaim  []string
+	routes      map[string][]authzRule
+}
+
+// 创建授权中间件
+func NewAuthorizer(cfg config.AuthzConfig) *Authorizer {
+	scopesClaim := cfg.ScopesClaim
+	if scopesClaim == "" {
+		scopesClaim = "scope"
+	}
+	rolesClaim := cfg.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+
+	routes := make(map[string][]authzRule)
+	for route, rules := range cfg.Routes {
+		for _, ruleCfg := range rules {
+			rule := authzRule{
+				scopes: ruleCfg.Scopes,
+				roles:  ruleCfg.Roles,
+				claims: ruleCfg.Claims,
+			}
+			if len(ruleCfg.Methods) > 0 {
+				rule.methods = make(map[string]bool)
+				for _, method := range ruleCfg.Methods {
+					rule.methods[strings.ToUpper(method)] = true
+				}
+			}
+			routes[route] = append(routes[route], rule)
+		}
+	}
+
+	return &Authorizer{
+		scopesClaim: strings.Split(scopesClaim, "."),
+		rolesClaim:  strings.Split(rolesClaim, "."),
+		routes:      routes,
+	}
+}
+
+// Gin 中间件处理函数，需在 JWTMiddleware.Handle 之后执行
+func (a *Authorizer) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules := a.rulesFor(c.Request.URL.Path)
+		if len(rules) == 0 {
+			c.Next()
+			return
+		}
+
+		// 无需认证的路径没有声明，任何规则都无法满足
+		var raw map[string]interface{}
+		if claims, ok := c.Get("claims"); ok {
+			raw = claims.(*Claims).Raw
+		}
+
+		for _, rule := range rules {
+			if rule.methods != nil && !rule.methods[c.Request.Method] {
+				continue
+			}
+			if code, message := a.check(rule, raw); code != "" {
+				abortWithCode(c, 403, code, message)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// 按最长前缀匹配路由的规则
+func (a *Authorizer) rulesFor(path string) []authzRule {
+	var rules []authzRule
+	var longestMatch string
+	for route, routeRules := range a.routes {
+		if strings.HasPrefix(path, route) && len(route) > len(longestMatch) {
+			rules = routeRules
+			longestMatch = route
+		}
+	}
+	return rules
+}
+
+// 检查单条规则，不满足时返回错误码与缺少的权限
+func (a *Authorizer) check(rule authzRule, raw map[string]interface{}) (string, string) {
+	if len(rule.scopes) > 0 {
+		granted := claimValues(raw, a.scopesClaim, true)
+		for _, scope := range rule.scopes {
+			if !granted[scope] {
+				return codeInsufficientScope, fmt.Sprintf("missing scope %s", scope)
+			}
+		}
+	}
+
+	if len(rule.roles) > 0 {
+		granted := claimValues(raw, a.rolesClaim, false)
+		found := false
+		for _, role := range rule.roles {
+			if granted[role] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return codeMissingRole, fmt.Sprintf("missing role %s", strings.Join(rule.roles, " or "))
+		}
+	}
+
+	for name, allowed := range rule.claims {
+		value, ok := lookupClaim(raw, strings.Split(name, "."))
+		if !ok || !claimMatches(value, allowed) {
+			return codeClaimNotAllowed, fmt.Sprintf("claim %s must be one of [%s]", name, strings.Join(allowed, ", "))
+		}
+	}
+
+	return "", ""
+}
+
+// 读取声明中的值集合，声明可以是数组或字符串，splitSpaces 时按空格拆分字符串 (OAuth2 scope 格式)
+func claimValues(raw map[string]interface{}, path []string, splitSpaces bool) map[string]bool {
+	values := make(map[string]bool)
+
+	value, ok := lookupClaim(raw, path)
+	if !ok {
+		return values
+	}
+
+	switch v := value.(type) {
+	case string:
+		if splitSpaces {
+			for _, item := range strings.Fields(v) {
+				values[item] = true
+			}
+		} else {
+			values[v] = true
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values[s] = true
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/grpcutil"
+)
+
+// 中止请求并返回错误，gRPC 请求以 gRPC 状态码返回，其余请求返回 JSON
+func abortWithError(c *gin.Context, status int, message string) {
+	abortWithCode(c, status, "", message)
+}
+
+// 中止请求并返回带错误码的错误，错误码便于客户端区分同一状态码下的不同原因
+func abortWithCode(c *gin.Context, status int, code, message string) {
+	if grpcutil.IsGRPCRequest(c.Request) {
+		grpcutil.WriteError(c.Writer, status, message)
+		c.Abort()
+		return
+	}
+
+	body := gin.H{"error": message}
+	if code != "" {
+		body["code"] = code
+	}
+	c.JSON(status, body)
+	c.Abort()
+}
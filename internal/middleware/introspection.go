@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 自省的默认参数
+const (
+	defaultIntrospectionTimeout  = 5 * time.Second
+	defaultIntrospectionCacheTTL = time.Minute // 无效结果以及没有 exp 的有效结果的缓存时间
+	introspectionPruneThreshold  = 10000       // 缓存超过该数量时清理过期的记录
+)
+
+// 自省端点不可用时响应中的错误码
+const codeIntrospectionUnavailable = "introspection_unavailable"
+
+// 自省端点不可用
+var errIntrospectionUnavailable = errors.New("token introspection unavailable")
+
+// 自省端点返回 active: false
+var errTokenInactive = &tokenError{codeInvalidToken, "token is not active"}
+
+// 通过授权服务器的自省端点 (RFC 7662) 校验不透明 token
+// 结果按 token 的 SHA-256 缓存，有效结果缓存到 token 过期，避免每个请求都调用自省端点
+type Introspector struct {
+	url              string
+	clientID         string
+	clientSecret     string
+	client           *http.Client
+	maxCacheTTL      time.Duration
+	inactiveCacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspectionEntry
+}
+
+type introspectionEntry struct {
+	claims  *Claims // nil 表示 token 无效
+	expires time.Time
+}
+
+// 创建自省客户端
+func NewIntrospector(cfg config.IntrospectionConfig) (*Introspector, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("introspection url is required")
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultIntrospectionTimeout
+	}
+	inactiveCacheTTL := cfg.InactiveCacheTTL
+	if inactiveCacheTTL <= 0 {
+		inactiveCacheTTL = defaultIntrospectionCacheTTL
+	}
+
+	return &Introspector{
+		url:              cfg.URL,
+		clientID:         cfg.ClientID,
+		clientSecret:     cfg.ClientSecret,
+		client:           &http.Client{Timeout: timeout},
+		maxCacheTTL:      cfg.MaxCacheTTL,
+		inactiveCacheTTL: inactiveCacheTTL,
+		cache:            make(map[[sha256.Size]byte]introspectionEntry),
+	}, nil
+}
+
+// 校验 token，返回自省端点给出的声明
+// token 无效时返回 errTokenInactive，自省端点不可用时返回的错误包含 errIntrospectionUnavailable
+func (i *Introspector) Introspect(ctx context.Context, token string) (*Claims, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	i.mu.Lock()
+	entry, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.claims == nil {
+			return nil, errTokenInactive
+		}
+		return entry.claims, nil
+	}
+
+	claims, err := i.fetch(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errIntrospectionUnavailable, err)
+	}
+
+	entry = introspectionEntry{claims: claims, expires: now.Add(i.cacheTTL(claims, now))}
+	i.mu.Lock()
+	if len(i.cache) >= introspectionPruneThreshold {
+		for k, e := range i.cache {
+			if !now.Before(e.expires) {
+				delete(i.cache, k)
+			}
+		}
+	}
+	i.cache[key] = entry
+	i.mu.Unlock()
+
+	if claims == nil {
+		return nil, errTokenInactive
+	}
+	return claims, nil
+}
+
+// 结果的缓存时间
+func (i *Introspector) cacheTTL(claims *Claims, now time.Time) time.Duration {
+	if claims == nil {
+		return i.inactiveCacheTTL
+	}
+
+	ttl := defaultIntrospectionCacheTTL
+	if claims.ExpiresAt != nil {
+		ttl = claims.ExpiresAt.Sub(now)
+	}
+	if i.maxCacheTTL > 0 && ttl > i.maxCacheTTL {
+		ttl = i.maxCacheTTL
+	}
+	return ttl
+}
+
+// 调用自省端点，token 无效时返回 nil
+func (i *Introspector) fetch(ctx context.Context, token string) (*Claims, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, "POST", i.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint: unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("introspection endpoint: %w", err)
+	}
+	if !result.Active {
+		return nil, nil
+	}
+	var claims Claims
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, fmt.Errorf("introspection endpoint: %w", err)
+	}
+
+	// 自省端点应只对未过期的 token 返回 active，这里再检查一次
+	if claims.ExpiresAt != nil && !time.Now().Before(claims.ExpiresAt.Time) {
+		return nil, nil
+	}
+
+	// RFC 7662 以 sub 表示用户
+	if claims.UserID == "" {
+		claims.UserID = claims.Subject
+	}
+	return &claims, nil
+}
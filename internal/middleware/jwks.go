@@ -0,0 +1,305 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// JWKS 默认参数
+const (
+	defaultJWKSRefreshInterval    = time.Hour
+	defaultJWKSMinRefreshInterval = time.Minute
+	defaultJWKSTimeout            = 5 * time.Second
+)
+
+// JWKS 响应的最大长度
+const maxJWKSSize = 1 << 20
+
+// JWKS 中的单个密钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// 从 JWKS 地址获取并缓存的公钥
+// 定时在后台刷新，获取失败时按最小间隔重试，并继续使用上一次成功获取的密钥
+// 遇到未知 kid 时按最小间隔限速刷新，已有刷新进行中时不等待
+type jwksCache struct {
+	url                string
+	client             *http.Client
+	allowed            func(alg string) bool
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+
+	mu   sync.RWMutex
+	keys []verificationKey
+
+	refreshMu   sync.Mutex // 保证同一时间只有一个刷新请求
+	lastAttempt time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// 创建 JWKS 缓存并启动后台刷新
+// 首次获取失败不会返回错误，身份提供方短暂不可用时网关仍可启动，后台会继续重试
+func newJWKSCache(cfg config.JWKSConfig, allowed func(alg string) bool) (*jwksCache, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("jwks url is required")
+	}
+
+	c := &jwksCache{
+		url:                cfg.URL,
+		allowed:            allowed,
+		refreshInterval:    cfg.RefreshInterval,
+		minRefreshInterval: cfg.MinRefreshInterval,
+		stop:               make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+	if c.refreshInterval <= 0 {
+		c.refreshInterval = defaultJWKSRefreshInterval
+	}
+	if c.minRefreshInterval <= 0 {
+		c.minRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultJWKSTimeout
+	}
+	c.client = &http.Client{Timeout: timeout}
+
+	err := c.refresh()
+	if err != nil {
+		log.Printf("Failed to fetch JWKS from %s: %v", c.url, err)
+	}
+
+	go c.run(err != nil)
+	return c, nil
+}
+
+// 当前缓存的密钥
+func (c *jwksCache) Keys() []verificationKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.keys
+}
+
+// 遇到未知 kid 时刷新，距上次刷新不足最小间隔或已有刷新进行中时直接返回
+// 身份提供方响应缓慢时，其他携带未知 kid 的请求不必排队等待
+func (c *jwksCache) refreshForKID(kid string) {
+	if !c.refreshMu.TryLock() {
+		return
+	}
+	defer c.refreshMu.Unlock()
+
+	if time.Since(c.lastAttempt) < c.minRefreshInterval {
+		return
+	}
+
+	if err := c.fetch(); err != nil {
+		log.Printf("Failed to refresh JWKS for kid %q: %v", kid, err)
+	}
+}
+
+// 立即刷新
+func (c *jwksCache) refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.fetch()
+}
+
+// 定时刷新，failed 表示上一次获取失败
+func (c *jwksCache) run(failed bool) {
+	defer close(c.done)
+
+	timer := time.NewTimer(c.nextRefresh(failed))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+			err := c.refresh()
+			if err != nil {
+				log.Printf("Failed to refresh JWKS from %s, keeping last known keys: %v", c.url, err)
+			}
+			timer.Reset(c.nextRefresh(err != nil))
+		}
+	}
+}
+
+// 距下一次定时刷新的时间，获取失败后按最小间隔重试，而不是等待完整的刷新间隔
+func (c *jwksCache) nextRefresh(failed bool) time.Duration {
+	if failed {
+		return c.minRefreshInterval
+	}
+	return c.refreshInterval
+}
+
+// 停止后台刷新
+func (c *jwksCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+// 获取 JWKS 并替换缓存，调用方需持有 refreshMu
+func (c *jwksCache) fetch() error {
+	c.lastAttempt = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	var keys []verificationKey
+	for _, jwk := range set.Keys {
+		// 跳过用于加密或算法未启用的密钥
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if jwk.Alg != "" && !c.allowed(jwk.Alg) {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", jwk.Kid, err)
+			continue
+		}
+		if jwk.Alg != "" && !keyMatchesMethod(key, jwt.GetSigningMethod(jwk.Alg)) {
+			log.Printf("Skipping JWKS key %q: key type does not match algorithm %s", jwk.Kid, jwk.Alg)
+			continue
+		}
+
+		keys = append(keys, verificationKey{kid: jwk.Kid, algorithm: jwk.Alg, key: key})
+	}
+
+	// 返回空集合视为异常，保留上一次的密钥
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable keys in jwks")
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+// 将 JWK 转换为公钥
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// 解码 base64url 编码的大整数
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
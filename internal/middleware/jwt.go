@@ -1,114 +1,344 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/proxy"
 )
 
 type JWTMiddleware struct {
-	secretKey string
-	exclude   []string
+	keys       *keySet
+	parser     *jwt.Parser
+	exclude    []string
+	queryParam string        // WebSocket 握手时读取 token 的查询参数
+	tokenTTL   time.Duration // 签发的访问 token 有效期
+	refreshTTL time.Duration // 签发的刷新 token 有效期
+
+	revocations auth.RevocationStore
+
+	issuer         string
+	audience       []string
+	routeAudience  map[string][]string
+	requiredClaims map[string][]string
+
+	claimHeaders map[string][]string // 请求头名 -> 声明路径
+	stripHeaders []string            // 转发前移除的客户端请求头
 }
 
+// 签发 token 的默认有效期
+const (
+	defaultTokenTTL   = 15 * time.Minute
+	defaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+// WebSocket 子协议中携带 token 的前缀，例如 Sec-WebSocket-Protocol: chat, bearer.<token>
+const wsProtocolTokenPrefix = "bearer."
+
 // JWT 的声明结构
 type Claims struct {
 	UserID   string `json:"userId"`
 	Username string `json:"username"`
+	Type     string `json:"typ,omitempty"` // token 类型，刷新 token 为 refresh
 	jwt.RegisteredClaims
+
+	Raw map[string]interface{} `json:"-"` // 解析得到的全部声明
 }
 
 // 创建 JWT 中间件
-func NewJWTMiddleware(secretKey string, exclude []string) *JWTMiddleware {
-	return &JWTMiddleware{
-		secretKey: secretKey,
-		exclude:   exclude,
+func NewJWTMiddleware(cfg config.JWTConfig) (*JWTMiddleware, error) {
+	keys, err := newKeySet(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	queryParam := cfg.QueryParam
+	if queryParam == "" {
+		queryParam = "access_token"
 	}
+
+	tokenTTL := cfg.TokenTTL
+	if tokenTTL <= 0 {
+		tokenTTL = defaultTokenTTL
+	}
+	refreshTTL := cfg.RefreshTTL
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTTL
+	}
+
+	// 解析器只接受允许列表中的算法，并校验 exp/nbf/iss
+	options := []jwt.ParserOption{jwt.WithValidMethods(keys.algorithms), jwt.WithLeeway(cfg.Leeway)}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+
+	routeAudience := make(map[string][]string)
+	for route, routeCfg := range cfg.Routes {
+		if len(routeCfg.Audience) > 0 {
+			routeAudience[route] = routeCfg.Audience
+		}
+	}
+
+	claimHeaders := make(map[string][]string)
+	var stripHeaders []string
+	for header, claim := range cfg.ClaimHeaders {
+		header = http.CanonicalHeaderKey(header)
+		claimHeaders[header] = strings.Split(claim, ".")
+		stripHeaders = append(stripHeaders, header)
+	}
+
+	return &JWTMiddleware{
+		keys:           keys,
+		parser:         jwt.NewParser(options...),
+		exclude:        cfg.Exclude,
+		queryParam:     queryParam,
+		tokenTTL:       tokenTTL,
+		refreshTTL:     refreshTTL,
+		revocations:    auth.NewMemoryRevocationStore(),
+		issuer:         cfg.Issuer,
+		audience:       cfg.Audience,
+		routeAudience:  routeAudience,
+		requiredClaims: cfg.RequiredClaims,
+		claimHeaders:   claimHeaders,
+		stripHeaders:   stripHeaders,
+	}, nil
 }
 
-// 生成 JWT token (用于测试)
+// 停止后台任务 (JWKS 刷新)
+func (m *JWTMiddleware) Close() {
+	m.keys.Close()
+}
+
+// 生成访问 token
 func (m *JWTMiddleware) GenerateToken(userID, username string) (string, error) {
+	return m.signToken(userID, username, "", m.tokenTTL)
+}
+
+// 签发 token，每个 token 带有唯一的 jti 以便吊销
+func (m *JWTMiddleware) signToken(userID, username, tokenType string, ttl time.Duration) (string, error) {
+	now := time.Now()
+
 	// 创建 claims
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
+		Type:     tokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24小时后过期
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        newTokenID(),
+			Issuer:    m.issuer,
+			Audience:  m.audience,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
+	// 网关只持有公钥时无法签发 token
+	method := m.keys.signingMethod()
+	if method == nil {
+		return "", errors.New("token signing requires an HMAC algorithm")
+	}
+
 	// 生成 token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(m.secretKey))
+	token := jwt.NewWithClaims(method, &issuedClaims{Claims: claims, IssuedAt: float64(now.UnixMilli()) / 1000})
+	return token.SignedString(m.keys.secret)
+}
+
+// 网关签发的 token 的 iat 精确到毫秒，NumericDate 默认只精确到秒
+// 吊销用户的所有 token 后，同一秒内重新登录签发的 token 不会被误判为已吊销
+type issuedClaims struct {
+	*Claims
+	IssuedAt float64 `json:"iat"`
 }
 
 // 验证 token
-func (m *JWTMiddleware) parseToken(tokenString string) (*Claims, error) {
+func (m *JWTMiddleware) parseToken(tokenString, path string) (*Claims, error) {
 	// 解析 token
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(m.secretKey), nil
-	})
+	token, err := m.parser.ParseWithClaims(tokenString, &Claims{}, m.keys.keyFunc)
 
 	if err != nil {
 		return nil, err
 	}
 
 	// 验证 claims
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	// 刷新 token 只能用于换取新的访问 token
+	if claims.Type == tokenTypeRefresh {
+		return nil, &tokenError{codeInvalidToken, "refresh token cannot be used as an access token"}
 	}
 
-	return nil, fmt.Errorf("invalid token")
+	if err := m.validateClaims(claims, path); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
 }
 
-// Gin 中间件处理函数
-func (m *JWTMiddleware) Handle() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 检查是否在排除列表中
-		path := c.Request.URL.Path
-		for _, exclude := range m.exclude {
-			if strings.HasPrefix(path, exclude) {
-				c.Next()
-				return
+// 从请求中获取 token
+// 优先使用 Authorization 头；WebSocket 握手时浏览器无法设置该头，
+// 依次尝试查询参数与 Sec-WebSocket-Protocol，并在转发前移除 token 避免泄露给上游
+func (m *JWTMiddleware) extractToken(c *gin.Context) (string, error) {
+	r := c.Request
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		// 解析 Bearer token
+		parts := strings.SplitN(authHeader, " ", 2)
+		if !(len(parts) == 2 && parts[0] == "Bearer") {
+			return "", &tokenError{codeTokenMalformed, "invalid authorization header format"}
+		}
+		return parts[1], nil
+	}
+
+	if proxy.IsWebSocketRequest(r) {
+		if token := takeQueryToken(r, m.queryParam); token != "" {
+			return token, nil
+		}
+		if token, only := takeProtocolToken(r); token != "" {
+			// 客户端只提供了携带 token 的协议时，由网关在 101 响应中回显该协议
+			if only {
+				c.Request = proxy.WithWebSocketProtocol(r, wsProtocolTokenPrefix+token)
 			}
+			return token, nil
 		}
+	}
+
+	return "", &tokenError{codeTokenMissing, "authorization header is required"}
+}
+
+// 读取并移除查询参数中的 token
+func takeQueryToken(r *http.Request, param string) string {
+	query := r.URL.Query()
+	token := query.Get(param)
+	if token == "" {
+		return ""
+	}
+
+	query.Del(param)
+	r.URL.RawQuery = query.Encode()
+	return token
+}
 
-		// 获取 token
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(401, gin.H{"error": "authorization header is required"})
-			c.Abort()
-			return
+// 读取并移除 Sec-WebSocket-Protocol 中的 token，only 表示客户端没有提供其他协议
+func takeProtocolToken(r *http.Request) (token string, only bool) {
+	var protocols []string
+
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, wsProtocolTokenPrefix) {
+				token = strings.TrimPrefix(protocol, wsProtocolTokenPrefix)
+				continue
+			}
+			if protocol != "" {
+				protocols = append(protocols, protocol)
+			}
 		}
+	}
 
-		// 解析 Bearer token
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			c.JSON(401, gin.H{"error": "invalid authorization header format"})
-			c.Abort()
-			return
+	if token == "" {
+		return "", false
+	}
+
+	if len(protocols) > 0 {
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	} else {
+		r.Header.Del("Sec-WebSocket-Protocol")
+	}
+	return token, len(protocols) == 0
+}
+
+// Gin 中间件处理函数
+func (m *JWTMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.authenticate(c) {
+			c.Next()
 		}
+	}
+}
+
+// 验证请求携带的 token，失败时中止请求并返回 false
+func (m *JWTMiddleware) authenticate(c *gin.Context) bool {
+	// 检查是否在排除列表中
+	path := c.Request.URL.Path
+	if m.excluded(path) {
+		// 未经认证的请求同样不能携带身份头
+		m.stripClaimHeaders(c)
+		return true
+	}
+
+	// 获取 token
+	tokenString, err := m.extractToken(c)
+	if err != nil {
+		te := classifyTokenError(err)
+		abortWithCode(c, 401, te.code, te.message)
+		return false
+	}
+
+	// 验证 token，按失败原因返回不同的错误码
+	claims, err := m.parseToken(tokenString, path)
+	if err != nil {
+		te := classifyTokenError(err)
+		abortWithCode(c, 401, te.code, te.message)
+		return false
+	}
+
+	// 检查 token 是否已被吊销
+	if err := m.checkRevoked(c.Request.Context(), claims); err != nil {
+		te := classifyTokenError(err)
+		abortWithCode(c, 401, te.code, te.message)
+		return false
+	}
 
-		// 验证 token
-		claims, err := m.parseToken(parts[1])
-		if err != nil {
-			c.JSON(401, gin.H{"error": "invalid token"})
-			c.Abort()
-			return
+	m.setIdentity(c, claims)
+	return true
+}
+
+// 路径是否在排除列表中
+func (m *JWTMiddleware) excluded(path string) bool {
+	for _, exclude := range m.exclude {
+		if strings.HasPrefix(path, exclude) {
+			return true
 		}
+	}
+	return false
+}
+
+// 将用户信息存储到上下文中，并转发声明给上游，避免每个服务重复解析 token
+func (m *JWTMiddleware) setIdentity(c *gin.Context, claims *Claims) {
+	c.Set("userId", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("claims", claims)
 
-		// 将用户信息存储到上下文中
-		c.Set("userId", claims.UserID)
-		c.Set("username", claims.Username)
+	if len(m.stripHeaders) > 0 {
+		c.Request = proxy.WithForwardHeaders(c.Request, m.stripHeaders, m.claimHeadersFor(claims))
+	}
+}
+
+// 请求的用户标识：网关签发的 token 使用 userId 声明，身份提供方签发的 token 通常只有 sub
+// 未认证的请求返回空字符串
+func requestSubject(c *gin.Context) string {
+	if userID := c.GetString("userId"); userID != "" {
+		return userID
+	}
+	if claims, ok := c.Get("claims"); ok {
+		return claims.(*Claims).Subject
+	}
+	return ""
+}
 
-		c.Next()
+// 移除客户端携带的声明请求头，用于未经 JWT 认证的请求
+func (m *JWTMiddleware) stripClaimHeaders(c *gin.Context) {
+	if len(m.stripHeaders) > 0 {
+		c.Request = proxy.WithForwardHeaders(c.Request, m.stripHeaders, nil)
 	}
 }
@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/net/http/httpguts"
+)
+
+// 认证失败时响应中的错误码
+const (
+	codeTokenMissing     = "token_missing"
+	codeTokenMalformed   = "token_malformed"
+	codeTokenExpired     = "token_expired"
+	codeTokenNotYetValid = "token_not_yet_valid"
+	codeInvalidSignature = "invalid_signature"
+	codeInvalidIssuer    = "invalid_issuer"
+	codeInvalidAudience  = "invalid_audience"
+	codeInvalidClaim     = "invalid_claim"
+	codeInvalidToken     = "invalid_token"
+)
+
+// token 校验失败的原因
+type tokenError struct {
+	code    string
+	message string
+}
+
+func (e *tokenError) Error() string {
+	return e.message
+}
+
+// 将解析错误归类为错误码
+func classifyTokenError(err error) *tokenError {
+	var te *tokenError
+	if errors.As(err, &te) {
+		return te
+	}
+
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return &tokenError{codeTokenMalformed, "malformed token"}
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return &tokenError{codeInvalidSignature, "invalid token signature"}
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return &tokenError{codeTokenExpired, "token has expired"}
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return &tokenError{codeTokenNotYetValid, "token is not valid yet"}
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return &tokenError{codeInvalidIssuer, "invalid token issuer"}
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return &tokenError{codeInvalidAudience, "invalid token audience"}
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return &tokenError{codeInvalidClaim, "missing required claim"}
+	default:
+		return &tokenError{codeInvalidToken, "invalid token"}
+	}
+}
+
+// 解析声明时同时保留原始内容，用于校验自定义声明
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Raw)
+}
+
+// 校验 aud 与必需声明，签名、exp、nbf、iss 已由解析器校验
+func (m *JWTMiddleware) validateClaims(claims *Claims, path string) error {
+	if audience := m.audienceFor(path); len(audience) > 0 && !containsAny(claims.Audience, audience) {
+		return &tokenError{codeInvalidAudience, "invalid token audience"}
+	}
+	return m.validateRequiredClaims(claims)
+}
+
+// 校验必需声明
+func (m *JWTMiddleware) validateRequiredClaims(claims *Claims) error {
+	for name, allowed := range m.requiredClaims {
+		value, ok := claims.Raw[name]
+		if !ok || value == nil {
+			return &tokenError{codeInvalidClaim, fmt.Sprintf("missing required claim %s", name)}
+		}
+		if !claimMatches(value, allowed) {
+			return &tokenError{codeInvalidClaim, fmt.Sprintf("claim %s has an unexpected value", name)}
+		}
+	}
+
+	return nil
+}
+
+// 按最长前缀匹配路由的 audience，未匹配时使用全局配置
+func (m *JWTMiddleware) audienceFor(path string) []string {
+	audience := m.audience
+	var longestMatch string
+	for route, routeAudience := range m.routeAudience {
+		if strings.HasPrefix(path, route) && len(route) > len(longestMatch) {
+			audience = routeAudience
+			longestMatch = route
+		}
+	}
+	return audience
+}
+
+// 两个列表是否有相同元素
+func containsAny(values, allowed []string) bool {
+	for _, v := range values {
+		for _, a := range allowed {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 声明值是否在允许列表中，数组类型的声明包含其一即可
+func claimMatches(value interface{}, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if claimMatches(item, allowed) {
+				return true
+			}
+		}
+		return false
+	case string, float64, bool, json.Number:
+		s := fmt.Sprint(v)
+		for _, a := range allowed {
+			if s == a {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// 根据配置生成转发给上游的请求头，缺失的声明不设置对应请求头
+func (m *JWTMiddleware) claimHeadersFor(claims *Claims) http.Header {
+	headers := make(http.Header)
+	for name, path := range m.claimHeaders {
+		value, ok := lookupClaim(claims.Raw, path)
+		if !ok {
+			continue
+		}
+		if s, ok := claimHeaderValue(value); ok && s != "" && httpguts.ValidHeaderFieldValue(s) {
+			headers.Set(name, s)
+		}
+	}
+	return headers
+}
+
+// 按路径读取声明，嵌套声明逐级查找
+func lookupClaim(claims map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = claims
+	for _, key := range path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[key]; !ok || value == nil {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// 将声明值转换为请求头的值，数组以逗号连接，对象编码为 JSON
+func claimHeaderValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := claimHeaderValue(item)
+			if !ok {
+				return "", false
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), true
+	case map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 未配置算法时的默认值，与旧版本仅支持共享密钥的行为一致
+var defaultAlgorithms = []string{"HS256"}
+
+// 验证签名的公钥
+type verificationKey struct {
+	kid       string
+	algorithm string // 为空时允许与密钥类型匹配的任意已启用算法
+	key       interface{}
+}
+
+// 验证签名的密钥集合
+// 算法与密钥按类型严格对应：HMAC 算法只使用共享密钥，非对称算法只使用对应类型的公钥，
+// 避免将公钥当作 HMAC 密钥的算法混淆攻击
+type keySet struct {
+	algorithms []string
+	secret     []byte
+	keys       []verificationKey
+	jwks       *jwksCache // 未配置 JWKS 时为 nil
+}
+
+// 根据配置创建密钥集合
+func newKeySet(cfg config.JWTConfig) (*keySet, error) {
+	algorithms := cfg.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = defaultAlgorithms
+	}
+
+	ks := &keySet{algorithms: algorithms, secret: []byte(cfg.SecretKey)}
+
+	var hmac, asymmetric bool
+	for _, alg := range algorithms {
+		method := jwt.GetSigningMethod(alg)
+		if method == nil || method == jwt.SigningMethodNone {
+			return nil, fmt.Errorf("unsupported jwt algorithm %q", alg)
+		}
+		if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+			hmac = true
+		} else {
+			asymmetric = true
+		}
+	}
+
+	if hmac && cfg.SecretKey == "" {
+		return nil, errors.New("jwt secretKey is required for HMAC algorithms")
+	}
+
+	kids := make(map[string]bool)
+	for _, keyCfg := range cfg.Keys {
+		if keyCfg.KID != "" {
+			if kids[keyCfg.KID] {
+				return nil, fmt.Errorf("duplicate jwt key id %q", keyCfg.KID)
+			}
+			kids[keyCfg.KID] = true
+		}
+
+		key, err := loadPublicKey(keyCfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", keyCfg.KID, err)
+		}
+
+		if keyCfg.Algorithm != "" {
+			if !ks.allowed(keyCfg.Algorithm) {
+				return nil, fmt.Errorf("jwt key %q: algorithm %s is not enabled", keyCfg.KID, keyCfg.Algorithm)
+			}
+			if !keyMatchesMethod(key, jwt.GetSigningMethod(keyCfg.Algorithm)) {
+				return nil, fmt.Errorf("jwt key %q: key type does not match algorithm %s", keyCfg.KID, keyCfg.Algorithm)
+			}
+		}
+
+		ks.keys = append(ks.keys, verificationKey{kid: keyCfg.KID, algorithm: keyCfg.Algorithm, key: key})
+	}
+
+	if asymmetric && len(ks.keys) == 0 && cfg.JWKS == nil {
+		return nil, errors.New("jwt keys or jwks are required for asymmetric algorithms")
+	}
+
+	if cfg.JWKS != nil {
+		jwks, err := newJWKSCache(*cfg.JWKS, ks.allowed)
+		if err != nil {
+			return nil, err
+		}
+		ks.jwks = jwks
+	}
+
+	return ks, nil
+}
+
+// 停止 JWKS 后台刷新
+func (ks *keySet) Close() {
+	if ks.jwks != nil {
+		ks.jwks.Close()
+	}
+}
+
+// 算法是否在允许列表中
+func (ks *keySet) allowed(alg string) bool {
+	for _, a := range ks.algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// 用于签发 token 的 HMAC 算法，未启用 HMAC 时返回 nil
+func (ks *keySet) signingMethod() jwt.SigningMethod {
+	for _, alg := range ks.algorithms {
+		if method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC); ok {
+			return method
+		}
+	}
+	return nil
+}
+
+// 按 token 的算法与 kid 选择验证密钥
+func (ks *keySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if !ks.allowed(alg) {
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return ks.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	candidates := ks.candidates(token.Method, kid)
+
+	// 身份提供方可能已轮换密钥，刷新 JWKS 后重试
+	if len(candidates) == 0 && kid != "" && ks.jwks != nil {
+		ks.jwks.refreshForKID(kid)
+		candidates = ks.candidates(token.Method, kid)
+	}
+
+	switch len(candidates) {
+	case 0:
+		if kid != "" {
+			return nil, fmt.Errorf("no %s key with id %q", alg, kid)
+		}
+		return nil, fmt.Errorf("no key for algorithm %s", alg)
+	case 1:
+		return candidates[0], nil
+	default:
+		// 未携带 kid 时依次尝试所有匹配的密钥
+		return jwt.VerificationKeySet{Keys: candidates}, nil
+	}
+}
+
+// 与算法和 kid 匹配的密钥，包括静态配置的公钥与 JWKS 中的公钥
+func (ks *keySet) candidates(method jwt.SigningMethod, kid string) []jwt.VerificationKey {
+	keys := ks.keys
+	if ks.jwks != nil {
+		keys = append(keys[:len(keys):len(keys)], ks.jwks.Keys()...)
+	}
+
+	var candidates []jwt.VerificationKey
+	for _, k := range keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.algorithm != "" && k.algorithm != method.Alg() {
+			continue
+		}
+		if !keyMatchesMethod(k.key, method) {
+			continue
+		}
+		candidates = append(candidates, k.key)
+	}
+	return candidates
+}
+
+// 公钥类型是否与签名算法匹配
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		k, ok := key.(*ecdsa.PublicKey)
+		return ok && k.Curve.Params().BitSize == m.CurveBits
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
+
+// 从 PEM 文件加载公钥，支持 PKIX 公钥、PKCS#1 RSA 公钥与证书
+func loadPublicKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/auth"
+)
+
+// 刷新 token 的类型声明
+const tokenTypeRefresh = "refresh"
+
+// token 已被吊销时的错误码
+const codeTokenRevoked = "token_revoked"
+
+// 吊销存储不可用
+var ErrRevocationUnavailable = errors.New("revocation store unavailable")
+
+// 登录或刷新得到的 token
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration // 访问 token 的有效期
+}
+
+// 替换吊销存储，多副本部署时使用共享存储使吊销在所有实例生效
+func (m *JWTMiddleware) SetRevocationStore(store auth.RevocationStore) {
+	m.revocations = store
+}
+
+// 签发访问 token 与刷新 token
+func (m *JWTMiddleware) IssueTokens(userID, username string) (*TokenPair, error) {
+	accessToken, err := m.signToken(userID, username, "", m.tokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := m.signToken(userID, username, tokenTypeRefresh, m.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresIn: m.tokenTTL}, nil
+}
+
+// 使用刷新 token 换取新的 token
+// 刷新 token 只能使用一次，重复使用或登出后继续使用说明可能已泄露，此时吊销该用户的所有 token
+func (m *JWTMiddleware) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	claims, err := m.parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// 用户已全部登出
+	if revoked, err := m.revocations.IsRevoked(ctx, "", claims.UserID, issuedAt(claims)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+	} else if revoked {
+		return nil, &tokenError{codeTokenRevoked, "token has been revoked"}
+	}
+
+	fresh, err := m.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+	}
+	if !fresh {
+		log.Printf("Refresh token reuse detected for user %s, revoking all tokens", claims.UserID)
+		if err := m.revocations.RevokeUser(ctx, claims.UserID, time.Now(), m.userRevocationTTL()); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+		}
+		return nil, &tokenError{codeTokenRevoked, "token has been revoked"}
+	}
+
+	return m.IssueTokens(claims.UserID, claims.Username)
+}
+
+// 登出：吊销访问 token 与可选的刷新 token，all 为 true 时吊销该用户的所有 token
+// 网关签发的 token 的 iat 精确到毫秒，吊销之后签发的 token 不受影响
+func (m *JWTMiddleware) Revoke(ctx context.Context, accessToken, refreshToken string, all bool) error {
+	claims, err := m.parseToken(accessToken, "")
+	if err != nil {
+		return err
+	}
+	if err := m.checkRevoked(ctx, claims); err != nil {
+		return err
+	}
+
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if _, err := m.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+		}
+	}
+
+	if refreshToken != "" {
+		refreshClaims, err := m.parseRefreshToken(refreshToken)
+		if err != nil {
+			return err
+		}
+		if refreshClaims.UserID != claims.UserID {
+			return &tokenError{codeInvalidToken, "refresh token belongs to another user"}
+		}
+		if _, err := m.revocations.RevokeToken(ctx, refreshClaims.ID, refreshClaims.ExpiresAt.Time); err != nil {
+			return fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+		}
+	}
+
+	if all {
+		if err := m.revocations.RevokeUser(ctx, claims.UserID, time.Now(), m.userRevocationTTL()); err != nil {
+			return fmt.Errorf("%w: %v", ErrRevocationUnavailable, err)
+		}
+	}
+	return nil
+}
+
+// 吊销用户的记录需保留到此前签发的 token 全部过期
+func (m *JWTMiddleware) userRevocationTTL() time.Duration {
+	return max(m.tokenTTL, m.refreshTTL)
+}
+
+// token 的签发时间，网关签发的 token 从原始声明中读取毫秒精度的 iat
+func issuedAt(claims *Claims) time.Time {
+	if iat, ok := claims.Raw["iat"].(float64); ok {
+		return time.UnixMilli(int64(math.Round(iat * 1000)))
+	}
+	if claims.IssuedAt != nil {
+		return claims.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
+// 检查 token 是否已被吊销
+func (m *JWTMiddleware) checkRevoked(ctx context.Context, claims *Claims) error {
+	revoked, err := m.revocations.IsRevoked(ctx, claims.ID, claims.UserID, issuedAt(claims))
+	if err != nil {
+		// 吊销存储不可用时拒绝请求
+		log.Printf("Revocation check failed: %v", err)
+		return &tokenError{codeInvalidToken, "unable to verify token"}
+	}
+	if revoked {
+		return &tokenError{codeTokenRevoked, "token has been revoked"}
+	}
+	return nil
+}
+
+// 解析刷新 token
+func (m *JWTMiddleware) parseRefreshToken(tokenString string) (*Claims, error) {
+	token, err := m.parser.ParseWithClaims(tokenString, &Claims{}, m.keys.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if claims.Type != tokenTypeRefresh || claims.ID == "" || claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return nil, &tokenError{codeInvalidToken, "not a refresh token"}
+	}
+
+	return claims, nil
+}
+
+// 将 token 校验错误转换为错误码与说明
+func TokenErrorCode(err error) (string, string) {
+	te := classifyTokenError(err)
+	return te.code, te.message
+}
+
+// 生成随机的 token ID
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/proxy"
+)
+
+// 请求因网关过载被丢弃时响应中的错误码
+const codeOverloaded = "overloaded"
+
+// 未配置类别时使用的优先级类别
+var defaultPriorityClasses = []config.PriorityClassConfig{
+	{Name: "critical", Share: 1},
+	{Name: "normal", Share: 0.8},
+	{Name: "low", Share: 0.5},
+}
+
+const defaultPriorityClass = "normal"
+
+// 优先级类别
+type priorityClass struct {
+	name  string
+	rank  int // 0 为最高优先级
+	limit int // 并发请求数低于该值时接受该类别的请求
+}
+
+// 负载保护中间件，限制网关全局的并发请求数
+// 各类别可使用的并发数按优先级递减，过载时低优先级的请求先被丢弃
+type LoadShedder struct {
+	enable       bool
+	classes      map[string]*priorityClass
+	defaultClass *priorityClass
+	routes       map[string]*priorityClass
+	claim        []string
+	header       string
+	inflight     atomic.Int64
+}
+
+// 创建负载保护中间件
+func NewLoadShedder(cfg config.LoadShedConfig) (*LoadShedder, error) {
+	if !cfg.Enable {
+		return &LoadShedder{}, nil
+	}
+	if cfg.MaxInFlight <= 0 {
+		return nil, errors.New("loadShed maxInFlight must be positive")
+	}
+
+	classCfgs := cfg.Classes
+	if len(classCfgs) == 0 {
+		classCfgs = defaultPriorityClasses
+	}
+	classes := make(map[string]*priorityClass)
+	for i, classCfg := range classCfgs {
+		if classCfg.Name == "" || classes[classCfg.Name] != nil {
+			return nil, fmt.Errorf("priority class %d: name must be unique and not empty", i)
+		}
+		if classCfg.Share <= 0 || classCfg.Share > 1 {
+			return nil, fmt.Errorf("priority class %s: share must be in (0, 1]", classCfg.Name)
+		}
+		// 低优先级的类别不能比高优先级的类别使用更多的并发
+		if i > 0 && classCfg.Share > classCfgs[i-1].Share {
+			return nil, fmt.Errorf("priority class %s: share must not exceed that of %s", classCfg.Name, classCfgs[i-1].Name)
+		}
+		classes[classCfg.Name] = &priorityClass{
+			name:  classCfg.Name,
+			rank:  i,
+			limit: int(math.Ceil(float64(cfg.MaxInFlight) * classCfg.Share)),
+		}
+	}
+
+	defaultName := cfg.DefaultClass
+	if defaultName == "" {
+		defaultName = defaultPriorityClass
+	}
+	defaultClass := classes[defaultName]
+	if defaultClass == nil {
+		return nil, fmt.Errorf("unknown default priority class %q", defaultName)
+	}
+
+	routes := make(map[string]*priorityClass)
+	for route, name := range cfg.Routes {
+		class := classes[name]
+		if class == nil {
+			return nil, fmt.Errorf("loadShed route %s: unknown priority class %q", route, name)
+		}
+		routes[route] = class
+	}
+
+	var claim []string
+	if cfg.Claim != "" {
+		claim = strings.Split(cfg.Claim, ".")
+	}
+
+	return &LoadShedder{
+		enable:       true,
+		classes:      classes,
+		defaultClass: defaultClass,
+		routes:       routes,
+		claim:        claim,
+		header:       cfg.Header,
+	}, nil
+}
+
+// 当前的并发请求数
+func (s *LoadShedder) InFlight() int {
+	return int(s.inflight.Load())
+}
+
+// 请求的优先级类别：路由的类别，声明指定的类别优先于路由
+// 请求头由客户端控制，只能降低优先级
+func (s *LoadShedder) classify(c *gin.Context) *priorityClass {
+	class := s.defaultClass
+
+	var longestMatch string
+	for route, routeClass := range s.routes {
+		if strings.HasPrefix(c.Request.URL.Path, route) && len(route) > len(longestMatch) {
+			longestMatch = route
+			class = routeClass
+		}
+	}
+
+	if s.claim != nil {
+		if claims, ok := c.Get("claims"); ok {
+			if value, ok := lookupClaim(claims.(*Claims).Raw, s.claim); ok {
+				if name, ok := value.(string); ok && s.classes[name] != nil {
+					class = s.classes[name]
+				}
+			}
+		}
+	}
+
+	if s.header != "" {
+		if headerClass := s.classes[c.GetHeader(s.header)]; headerClass != nil && headerClass.rank > class.rank {
+			class = headerClass
+		}
+	}
+	return class
+}
+
+// Gin 中间件处理函数，按声明指定类别时需在认证中间件之后执行
+// WebSocket 连接由各路由的连接数限制，不计入并发请求数
+func (s *LoadShedder) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.enable || proxy.IsWebSocketRequest(c.Request) {
+			c.Next()
+			return
+		}
+
+		class := s.classify(c)
+		if n := s.inflight.Add(1); n > int64(class.limit) {
+			s.inflight.Add(-1)
+			c.Header("Retry-After", "1")
+			c.Header("X-Priority-Class", class.name)
+			abortWithCode(c, 503, codeOverloaded, "gateway overloaded")
+			return
+		}
+		defer s.inflight.Add(-1)
+
+		c.Next()
+	}
+}
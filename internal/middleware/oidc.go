@@ -0,0 +1,445 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/proxy"
+)
+
+// OIDC 登录的默认参数
+const (
+	defaultOIDCCookieName = "gogate_session"
+	defaultOIDCLogoutPath = "/auth/oidc/logout"
+	defaultOIDCSessionTTL = 8 * time.Hour
+	defaultOIDCTimeout    = 5 * time.Second
+	oidcStateTTL          = 10 * time.Minute // 从跳转登录到回调的最长时间
+	minCookieSecretLength = 32
+)
+
+// OIDC 登录失败时响应中的错误码
+const (
+	codeOIDCUnavailable = "oidc_unavailable"
+	codeOIDCLoginFailed = "oidc_login_failed"
+)
+
+// 未配置 scopes 时请求的默认值
+var defaultOIDCScopes = []string{"openid", "profile", "email"}
+
+// ID token 允许的签名算法
+var oidcAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}
+
+// 身份提供方的发现文档
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// 跳转登录时保存在 cookie 中的状态，回调时校验
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code_verifier
+	ReturnTo string `json:"returnTo"` // 登录完成后返回的地址
+}
+
+// 在网关完成 OpenID Connect 授权码登录 (PKCE)，会话保存在加密 cookie 中
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	callbackPath string
+	logoutPath   string
+	scopes       []string
+
+	cookieName      string
+	stateCookieName string
+	secureCookie    bool
+	sessionTTL      time.Duration
+	codec           *cookieCodec
+	client          *http.Client
+
+	// 首次使用时从发现文档获取端点与公钥，身份提供方短暂不可用时网关仍可启动
+	mu       sync.Mutex
+	metadata *oidcMetadata
+	keys     *keySet
+	parser   *jwt.Parser
+}
+
+// 创建 OIDC 登录
+func NewOIDCProvider(cfg config.OIDCConfig) (*OIDCProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc issuer and clientId are required")
+	}
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil || !redirect.IsAbs() || redirect.Path == "" {
+		return nil, errors.New("oidc redirectURL must be an absolute URL")
+	}
+	if len(cfg.CookieSecret) < minCookieSecretLength {
+		return nil, fmt.Errorf("oidc cookieSecret must be at least %d characters", minCookieSecretLength)
+	}
+
+	codec, err := newCookieCodec(cfg.CookieSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOIDCScopes
+	}
+	logoutPath := cfg.LogoutPath
+	if logoutPath == "" {
+		logoutPath = defaultOIDCLogoutPath
+	}
+	cookieName := cfg.CookieName
+	if cookieName == "" {
+		cookieName = defaultOIDCCookieName
+	}
+	sessionTTL := cfg.SessionTTL
+	if sessionTTL <= 0 {
+		sessionTTL = defaultOIDCSessionTTL
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultOIDCTimeout
+	}
+
+	return &OIDCProvider{
+		issuer:          strings.TrimSuffix(cfg.Issuer, "/"),
+		clientID:        cfg.ClientID,
+		clientSecret:    cfg.ClientSecret,
+		redirectURL:     cfg.RedirectURL,
+		callbackPath:    redirect.Path,
+		logoutPath:      logoutPath,
+		scopes:          scopes,
+		cookieName:      cookieName,
+		stateCookieName: cookieName + "_state",
+		secureCookie:    !cfg.InsecureCookie,
+		sessionTTL:      sessionTTL,
+		codec:           codec,
+		client:          &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// 回调路径
+func (p *OIDCProvider) CallbackPath() string {
+	return p.callbackPath
+}
+
+// 清除会话的路径
+func (p *OIDCProvider) LogoutPath() string {
+	return p.logoutPath
+}
+
+// 停止后台任务 (JWKS 刷新)
+func (p *OIDCProvider) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys != nil {
+		p.keys.Close()
+	}
+}
+
+// 读取请求携带的会话，没有有效会话时返回 nil
+func (p *OIDCProvider) session(r *http.Request) *Claims {
+	cookie, err := r.Cookie(p.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	var claims Claims
+	if err := p.codec.decode(p.cookieName, cookie.Value, &claims); err != nil {
+		return nil
+	}
+
+	// ID token 以 sub 表示用户
+	if claims.UserID == "" {
+		claims.UserID = claims.Subject
+	}
+	if claims.Username == "" {
+		claims.Username, _ = claims.Raw["preferred_username"].(string)
+	}
+	return &claims
+}
+
+// 移除会话 cookie，避免转发给上游
+func (p *OIDCProvider) removeSessionCookie(r *http.Request) {
+	var kept []string
+	for _, cookie := range r.Cookies() {
+		if cookie.Name != p.cookieName {
+			kept = append(kept, cookie.String())
+		}
+	}
+
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	} else {
+		r.Header.Del("Cookie")
+	}
+}
+
+// 是否为浏览器页面访问，只有这类请求跳转登录，API 调用仍返回 401
+func isBrowserNavigation(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		strings.Contains(r.Header.Get("Accept"), "text/html") &&
+		!proxy.IsWebSocketRequest(r)
+}
+
+// 跳转到身份提供方登录
+func (p *OIDCProvider) redirectToLogin(c *gin.Context) {
+	metadata, _, err := p.discover(c.Request.Context())
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		abortWithCode(c, 503, codeOIDCUnavailable, "identity provider unavailable")
+		return
+	}
+
+	state := oidcState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString() + randomString(),
+		ReturnTo: c.Request.URL.RequestURI(),
+	}
+	value, err := p.codec.encode(p.stateCookieName, state, time.Now().Add(oidcStateTTL))
+	if err != nil {
+		abortWithError(c, 500, "failed to start login")
+		return
+	}
+	p.setCookie(c, p.stateCookieName, value, p.callbackPath, oidcStateTTL)
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, metadata.AuthorizationEndpoint+separator+query.Encode())
+	c.Abort()
+}
+
+// 处理身份提供方的回调：校验 state，用授权码与 code_verifier 换取 ID token，写入会话 cookie
+func (p *OIDCProvider) HandleCallback(c *gin.Context) {
+	// state cookie 只能使用一次
+	stateCookie, _ := c.Cookie(p.stateCookieName)
+	p.setCookie(c, p.stateCookieName, "", p.callbackPath, -1)
+
+	var state oidcState
+	if stateCookie == "" || p.codec.decode(p.stateCookieName, stateCookie, &state) != nil ||
+		subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(state.State)) != 1 {
+		c.JSON(400, gin.H{"error": "invalid or expired login state", "code": codeOIDCLoginFailed})
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(401, gin.H{"error": "login failed: " + errCode, "code": codeOIDCLoginFailed})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(400, gin.H{"error": "missing authorization code", "code": codeOIDCLoginFailed})
+		return
+	}
+
+	claims, err := p.exchange(c.Request.Context(), code, state)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		c.JSON(401, gin.H{"error": "login failed", "code": codeOIDCLoginFailed})
+		return
+	}
+
+	// 会话只保存 ID token 的声明
+	delete(claims.Raw, "nonce")
+	value, err := p.codec.encode(p.cookieName, claims.Raw, time.Now().Add(p.sessionTTL))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "failed to create session"})
+		return
+	}
+	p.setCookie(c, p.cookieName, value, "/", p.sessionTTL)
+
+	// 只允许返回本站的相对地址，避免开放重定向
+	returnTo := state.ReturnTo
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		returnTo = "/"
+	}
+	c.Redirect(http.StatusFound, returnTo)
+}
+
+// 清除会话
+func (p *OIDCProvider) HandleLogout(c *gin.Context) {
+	p.setCookie(c, p.cookieName, "", "/", -1)
+	c.Status(http.StatusNoContent)
+}
+
+// 用授权码换取并校验 ID token
+func (p *OIDCProvider) exchange(ctx context.Context, code string, state oidcState) (*Claims, error) {
+	metadata, parser, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {state.Verifier},
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token endpoint: response has no id_token")
+	}
+
+	token, err := parser.ParseWithClaims(tokens.IDToken, &Claims{}, p.keys.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("id token: %w", err)
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("id token: invalid")
+	}
+	nonce, _ := claims.Raw["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(state.Nonce)) != 1 {
+		return nil, errors.New("id token: nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// 获取发现文档，成功后缓存
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, *jwt.Parser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, p.parser, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("discovery: unexpected status %d", resp.StatusCode)
+	}
+
+	var metadata oidcMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&metadata); err != nil {
+		return nil, nil, fmt.Errorf("discovery: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.issuer {
+		return nil, nil, fmt.Errorf("discovery: issuer %q does not match %q", metadata.Issuer, p.issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, nil, errors.New("discovery: missing endpoints")
+	}
+
+	keys, err := newKeySet(config.JWTConfig{
+		Algorithms: oidcAlgorithms,
+		JWKS:       &config.JWKSConfig{URL: metadata.JWKSURI, Timeout: p.client.Timeout},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.metadata = &metadata
+	p.keys = keys
+	p.parser = jwt.NewParser(
+		jwt.WithValidMethods(oidcAlgorithms),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+	)
+	return p.metadata, p.parser, nil
+}
+
+// 写入 cookie，maxAge 为负数时删除
+func (p *OIDCProvider) setCookie(c *gin.Context, name, value, path string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   p.secureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// 生成随机字符串，用于 state、nonce 与 code_verifier
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// cookie 无法解密、已过期或被篡改
+var errInvalidCookie = errors.New("invalid cookie")
+
+// 使用 AES-GCM 加密的 cookie 内容，cookie 名称作为附加数据，避免将一种 cookie 当作另一种使用
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+// 带过期时间的 cookie 内容
+type cookieEnvelope struct {
+	Exp  int64           `json:"exp"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newCookieCodec(secret string) (*cookieCodec, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieCodec{aead: aead}, nil
+}
+
+// 加密 cookie 内容
+func (cc *cookieCodec) encode(name string, value interface{}, expires time.Time) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(cookieEnvelope{Exp: expires.Unix(), Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, cc.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := cc.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// 解密 cookie 内容
+func (cc *cookieCodec) decode(name, encoded string, value interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < cc.aead.NonceSize() {
+		return errInvalidCookie
+	}
+
+	nonce, ciphertext := sealed[:cc.aead.NonceSize()], sealed[cc.aead.NonceSize():]
+	plaintext, err := cc.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return errInvalidCookie
+	}
+
+	var envelope cookieEnvelope
+	if err := json.Unmarshal(plaintext, &envelope); err != nil {
+		return errInvalidCookie
+	}
+	if time.Now().Unix() >= envelope.Exp {
+		return errInvalidCookie
+	}
+	if err := json.Unmarshal(envelope.Data, value); err != nil {
+		return errInvalidCookie
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 配额用完时响应中的错误码，与限流的 rate_limited 区分
+const codeQuotaExceeded = "quota_exceeded"
+
+// 默认的配额管理接口
+const defaultQuotaAdminPath = "/admin/quotas"
+
+// 配额周期
+const (
+	quotaPeriodDaily   = "daily"
+	quotaPeriodMonthly = "monthly"
+)
+
+// 调用方的用量配额，按天与按月计数，周期按 UTC 计算
+type QuotaManager struct {
+	enable    bool
+	defaults  config.QuotaLimitConfig
+	tiers     map[string]config.QuotaLimitConfig
+	consumers map[string]config.QuotaLimitConfig
+	adminPath string
+	store     QuotaStore
+	clock     Clock
+}
+
+// 一个周期的配额与用量
+type quotaUsage struct {
+	Period    string    `json:"period"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// 创建用量配额，启用时必须配置管理角色，避免管理接口对所有已认证用户开放
+func NewQuotaManager(cfg config.QuotaConfig) (*QuotaManager, error) {
+	if !cfg.Enable {
+		return &QuotaManager{store: NewMemoryQuotaStore(), clock: systemClock{}}, nil
+	}
+	if len(cfg.AdminRoles) == 0 {
+		return nil, errors.New("quota adminRoles is required")
+	}
+
+	var store QuotaStore
+	switch cfg.Store.Type {
+	case "", config.QuotaStoreMemory:
+		store = NewMemoryQuotaStore()
+	case config.QuotaStoreFile:
+		fileStore, err := NewFileQuotaStore(cfg.Store.Path, cfg.Store.FlushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("quota store: %w", err)
+		}
+		store = fileStore
+	default:
+		return nil, fmt.Errorf("unsupported quota store %q", cfg.Store.Type)
+	}
+
+	adminPath := cfg.AdminPath
+	if adminPath == "" {
+		adminPath = defaultQuotaAdminPath
+	}
+
+	return &QuotaManager{
+		enable:    true,
+		defaults:  config.QuotaLimitConfig{Daily: cfg.Daily, Monthly: cfg.Monthly},
+		tiers:     cfg.Tiers,
+		consumers: cfg.Consumers,
+		adminPath: adminPath,
+		store:     store,
+		clock:     systemClock{},
+	}, nil
+}
+
+// 替换用量的存储
+func (q *QuotaManager) SetStore(store QuotaStore) {
+	q.store = store
+}
+
+// 替换时钟
+func (q *QuotaManager) SetClock(clock Clock) {
+	q.clock = clock
+}
+
+// 是否启用配额
+func (q *QuotaManager) Enabled() bool {
+	return q.enable
+}
+
+// 管理接口的路径
+func (q *QuotaManager) AdminPath() string {
+	return q.adminPath
+}
+
+// 关闭存储，基于文件的存储在关闭时写入最新的用量
+func (q *QuotaManager) Close() error {
+	if closer, ok := q.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// 请求对应的调用方与其 tier，未认证的请求返回 false
+func quotaConsumer(c *gin.Context) (string, string, bool) {
+	if value, ok := c.Get("consumer"); ok {
+		consumer := value.(*auth.Consumer)
+		return "apiKey:" + consumer.Name, consumer.Tier, true
+	}
+	if subject := requestSubject(c); subject != "" {
+		return "user:" + subject, "", true
+	}
+	return "", "", false
+}
+
+// 调用方的配额，按调用方、tier、默认值的顺序查找
+func (q *QuotaManager) limits(consumer, tier string) config.QuotaLimitConfig {
+	if limits, ok := q.consumers[consumer]; ok {
+		return limits
+	}
+	if limits, ok := q.tiers[tier]; ok && tier != "" {
+		return limits
+	}
+	return q.defaults
+}
+
+// 当前的周期：名称、存储中的周期 key、配额与周期结束时间
+type quotaPeriod struct {
+	name  string
+	key   string
+	limit int64
+	reset time.Time
+}
+
+// 配额不为 0 的周期
+func (q *QuotaManager) periods(limits config.QuotaLimitConfig) []quotaPeriod {
+	now := q.clock.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var periods []quotaPeriod
+	if limits.Daily > 0 {
+		periods = append(periods, quotaPeriod{
+			name:  quotaPeriodDaily,
+			key:   quotaPeriodDaily + ":" + day.Format("2006-01-02"),
+			limit: limits.Daily,
+			reset: day.AddDate(0, 0, 1),
+		})
+	}
+	if limits.Monthly > 0 {
+		periods = append(periods, quotaPeriod{
+			name:  quotaPeriodMonthly,
+			key:   quotaPeriodMonthly + ":" + month.Format("2006-01"),
+			limit: limits.Monthly,
+			reset: month.AddDate(0, 1, 0),
+		})
+	}
+	return periods
+}
+
+// Gin 中间件处理函数，需在认证中间件之后执行
+// 依次在各周期计入用量，任一周期超出配额时退还已计入的用量并返回 429
+func (q *QuotaManager) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		consumer, tier, ok := quotaConsumer(c)
+		if !q.enable || !ok {
+			c.Next()
+			return
+		}
+		periods := q.periods(q.limits(consumer, tier))
+		if len(periods) == 0 {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		remaining := int64(-1)
+		for i, period := range periods {
+			used, err := q.store.Add(ctx, consumer, period.key, 1)
+			if err != nil {
+				// 存储不可用时不阻断请求
+				log.Printf("Quota store failed: %v", err)
+				q.refund(c, consumer, periods[:i])
+				c.Next()
+				return
+			}
+
+			if used > period.limit {
+				q.refund(c, consumer, periods[:i+1])
+				c.Header("X-Quota-Remaining", "0")
+				c.Header("Retry-After", strconv.FormatInt(ceilSeconds(period.reset.Sub(q.clock.Now())), 10))
+				abortWithCode(c, 429, codeQuotaExceeded, fmt.Sprintf("%s quota exceeded", period.name))
+				return
+			}
+			if left := period.limit - used; remaining < 0 || left < remaining {
+				remaining = left
+			}
+		}
+
+		c.Header("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+		c.Next()
+	}
+}
+
+// 退还已计入的用量
+func (q *QuotaManager) refund(c *gin.Context, consumer string, periods []quotaPeriod) {
+	for _, period := range periods {
+		if _, err := q.store.Add(c.Request.Context(), consumer, period.key, -1); err != nil {
+			log.Printf("Failed to refund quota usage: %v", err)
+		}
+	}
+}
+
+// 查看调用方的配额与当前周期的用量，tier 通过查询参数指定
+// GET <adminPath>/:consumer?tier=gold
+func (q *QuotaManager) HandleView(c *gin.Context) {
+	consumer := c.Param("consumer")
+	usage, err := q.store.Usage(c.Request.Context(), consumer)
+	if err != nil {
+		abortWithError(c, 503, "quota store unavailable")
+		return
+	}
+
+	quotas := []quotaUsage{}
+	for _, period := range q.periods(q.limits(consumer, c.Query("tier"))) {
+		used := usage[period.key]
+		remaining := period.limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		quotas = append(quotas, quotaUsage{
+			Period:    period.name,
+			Limit:     period.limit,
+			Used:      used,
+			Remaining: remaining,
+			Reset:     period.reset,
+		})
+	}
+	c.JSON(200, gin.H{"consumer": consumer, "quotas": quotas})
+}
+
+// 重置调用方的用量，period 为 daily 或 monthly，不指定时重置所有周期
+// DELETE <adminPath>/:consumer?period=daily
+func (q *QuotaManager) HandleReset(c *gin.Context) {
+	consumer := c.Param("consumer")
+
+	var key string
+	switch period := c.Query("period"); period {
+	case "":
+	case quotaPeriodDaily, quotaPeriodMonthly:
+		for _, p := range q.periods(config.QuotaLimitConfig{Daily: 1, Monthly: 1}) {
+			if p.name == period {
+				key = p.key
+			}
+		}
+	default:
+		abortWithError(c, 400, "period must be daily or monthly")
+		return
+	}
+
+	if err := q.store.Reset(c.Request.Context(), consumer, key); err != nil {
+		abortWithError(c, 503, "quota store unavailable")
+		return
+	}
+	c.Status(204)
+}
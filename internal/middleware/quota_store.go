@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认写入文件的间隔
+const defaultQuotaFlushInterval = 10 * time.Second
+
+// 配额用量的存储，多副本部署时可替换为共享存储
+// 周期名称为 <类型>:<编号>，如 daily:2026-10-19，同一调用方同类周期只需保留最新的
+type QuotaStore interface {
+	// 用量加 n 并返回之后的用量，n 为负数时退还
+	Add(ctx context.Context, consumer, period string, n int64) (int64, error)
+	// 调用方在各周期的用量
+	Usage(ctx context.Context, consumer string) (map[string]int64, error)
+	// 清零调用方在某一周期的用量，period 为空时清零所有周期
+	Reset(ctx context.Context, consumer, period string) error
+}
+
+// 基于内存的配额存储，重启后用量清零
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]map[string]int64 // 调用方 -> 周期 -> 用量
+	dirty bool                        // 上次写入文件后是否有修改
+}
+
+// 创建基于内存的配额存储
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: make(map[string]map[string]int64)}
+}
+
+// 用量加 n，同时删除同类的旧周期
+func (s *MemoryQuotaStore) Add(ctx context.Context, consumer, period string, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	periods, ok := s.usage[consumer]
+	if !ok {
+		periods = make(map[string]int64)
+		s.usage[consumer] = periods
+	}
+
+	kind, _, _ := strings.Cut(period, ":")
+	for p := range periods {
+		if p != period && strings.HasPrefix(p, kind+":") {
+			delete(periods, p)
+		}
+	}
+
+	used := periods[period] + n
+	if used < 0 {
+		used = 0
+	}
+	periods[period] = used
+	s.dirty = true
+	return used, nil
+}
+
+// 调用方在各周期的用量
+func (s *MemoryQuotaStore) Usage(ctx context.Context, consumer string) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make(map[string]int64)
+	for period, used := range s.usage[consumer] {
+		usage[period] = used
+	}
+	return usage, nil
+}
+
+// 清零调用方的用量
+func (s *MemoryQuotaStore) Reset(ctx context.Context, consumer, period string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if period == "" {
+		delete(s.usage, consumer)
+	} else {
+		delete(s.usage[consumer], period)
+	}
+	s.dirty = true
+	return nil
+}
+
+// 基于文件的配额存储，用量保存在内存中并定期写入 JSON 文件，重启后从文件恢复
+// 两次写入之间的用量在进程崩溃时会丢失
+type FileQuotaStore struct {
+	*MemoryQuotaStore
+	path string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// 创建基于文件的配额存储，文件不存在时从零开始
+func NewFileQuotaStore(path string, flushInterval time.Duration) (*FileQuotaStore, error) {
+	if path == "" {
+		return nil, errors.New("quota store path is required")
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultQuotaFlushInterval
+	}
+
+	memory := NewMemoryQuotaStore()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &memory.usage); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	s := &FileQuotaStore{
+		MemoryQuotaStore: memory,
+		path:             path,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+	go s.flushLoop(flushInterval)
+	return s, nil
+}
+
+func (s *FileQuotaStore) flushLoop(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("Failed to write quota usage to %s: %v", s.path, err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// 将用量写入文件，先写临时文件再重命名，避免写入中途崩溃损坏文件
+func (s *FileQuotaStore) Flush() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.usage)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		s.markDirty()
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		s.markDirty()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		s.markDirty()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		s.markDirty()
+		return err
+	}
+	return nil
+}
+
+// 写入失败时保留修改标记，下次重试
+func (s *FileQuotaStore) markDirty() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+}
+
+// 停止定期写入并写入最新的用量
+func (s *FileQuotaStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
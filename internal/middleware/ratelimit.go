@@ -1,6 +1,10 @@
 package middleware
 
 import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +16,7 @@ import (
 // 令牌桶限流器
 type TokenBucket struct {
 	mu         sync.Mutex
+	clock      Clock
 	rate       float64   // 令牌生成速率，每秒生成的令牌数
 	burst      int       // 桶的容量，最大令牌数
 	tokens     float64   // 当前令牌数
@@ -20,21 +25,31 @@ type TokenBucket struct {
 
 // 创建新的令牌桶限流器
 func NewTokenBucket(rate int, burst int) *TokenBucket {
+	return newTokenBucket(rate, burst, systemClock{})
+}
+
+func newTokenBucket(rate int, burst int, clock Clock) *TokenBucket {
 	return &TokenBucket{
+		clock:      clock,
 		rate:       float64(rate),
 		burst:      burst,
 		tokens:     float64(burst), // 初始填满令牌桶
-		lastUpdate: time.Now(),
+		lastUpdate: clock.Now(),
 	}
 }
 
 // Allow 判断是否允许请求通过
 func (tb *TokenBucket) Allow() bool {
+	return tb.Take().Allowed
+}
+
+// Take 判断是否允许请求通过，并返回令牌桶的状态
+func (tb *TokenBucket) Take() RateLimitResult {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
 	// 计算从上次更新到现在经过的时间
-	now := time.Now()
+	now := tb.clock.Now()
 	elapsed := now.Sub(tb.lastUpdate).Seconds()
 	tb.lastUpdate = now
 
@@ -49,44 +64,191 @@ func (tb *TokenBucket) Allow() bool {
 	}
 
 	// 如果至少有1个令牌，则消耗一个令牌并允许请求
+	result := RateLimitResult{Limit: tb.burst}
 	if tb.tokens >= 1 {
 		tb.tokens--
-		return true
+		result.Allowed = true
+	} else if tb.rate > 0 {
+		// 没有足够的令牌，请求被拒绝，补充一个令牌后可重试
+		result.RetryAfter = seconds((1 - tb.tokens) / tb.rate)
 	}
 
-	// 没有足够的令牌，请求被拒绝
-	return false
+	result.Remaining = int(tb.tokens)
+	if tb.rate > 0 {
+		result.Reset = seconds((float64(tb.burst) - tb.tokens) / tb.rate)
+	}
+	return result
+}
+
+// 请求被限流时响应中的错误码
+const codeRateLimited = "rate_limited"
+
+// Refund 退还一个令牌，不超过桶的容量，令牌桶不区分请求
+func (tb *TokenBucket) Refund(string) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.tokens = math.Min(float64(tb.burst), tb.tokens+1)
 }
 
 // RateLimiter 限流中间件
 type RateLimiter struct {
-	globalLimiter *TokenBucket
-	routeLimiters map[string]*TokenBucket
+	globalLimiter *rateLimitRule
+	routeLimiters map[string]*rateLimitRule
+	backend       RateLimitBackend
+	local         *MemoryRateLimitBackend // 共享存储不可用时使用的本地限流
+	exempt        []string
 	config        config.RateLimitConfig
 }
 
+// 始终不限流的路径，与配置的 exempt 合并，避免负载均衡器的健康检查被限流
+var rateLimitAlwaysExempt = []string{"/health"}
+
+// 一条限流规则，key 为空时规则内的请求共享限额，否则每个客户端分别计数
+type rateLimitRule struct {
+	name   string
+	key    string
+	policy RateLimitPolicy
+}
+
+func newRateLimitRule(name, key string, policy RateLimitPolicy) (*rateLimitRule, error) {
+	if err := validateRateLimitKey(key); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &rateLimitRule{name: name, key: key, policy: policy}, nil
+}
+
 // NewRateLimiter 创建限流中间件
-func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
-	globalLimiter := NewTokenBucket(cfg.Rate, cfg.Burst)
-	routeLimiters := make(map[string]*TokenBucket)
+func NewRateLimiter(cfg config.RateLimitConfig) (*RateLimiter, error) {
+	globalLimiter, err := newRateLimitRule("global", cfg.Key, RateLimitPolicy{
+		Algorithm: cfg.Algorithm,
+		Rate:      cfg.Rate,
+		Burst:     cfg.Burst,
+		Limit:     cfg.Limit,
+		Window:    cfg.Window,
+	})
+	if err != nil {
+		return nil, err
+	}
+	routeLimiters := make(map[string]*rateLimitRule)
 
-	// 为每个指定路由创建限流器
+	// 为每个指定路由创建限流器，未指定 key 时沿用全局的 key
 	for route, routeCfg := range cfg.Routes {
-		routeLimiters[route] = NewTokenBucket(routeCfg.Rate, routeCfg.Burst)
+		key := routeCfg.Key
+		if key == "" {
+			key = cfg.Key
+		}
+		limiter, err := newRateLimitRule("route:"+route, key, RateLimitPolicy{
+			Algorithm: routeCfg.Algorithm,
+			Rate:      routeCfg.Rate,
+			Burst:     routeCfg.Burst,
+			Limit:     routeCfg.Limit,
+			Window:    routeCfg.Window,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("rate limit route %s: %w", route, err)
+		}
+		routeLimiters[route] = limiter
+	}
+
+	local := NewMemoryRateLimitBackend(cfg.MaxKeys)
+	var backend RateLimitBackend = local
+	switch cfg.Backend.Type {
+	case "", config.RateLimitBackendMemory:
+	case config.RateLimitBackendRedis:
+		redisBackend, err := NewRedisRateLimitBackend(cfg.Backend.Redis, cfg.Backend.RetryInterval)
+		if err != nil {
+			return nil, err
+		}
+		backend = redisBackend
+	default:
+		return nil, fmt.Errorf("unsupported rate limit backend %q", cfg.Backend.Type)
 	}
 
+	exempt := append(append([]string{}, rateLimitAlwaysExempt...), cfg.Exempt...)
+
 	return &RateLimiter{
 		globalLimiter: globalLimiter,
 		routeLimiters: routeLimiters,
+		backend:       backend,
+		local:         local,
+		exempt:        exempt,
 		config:        cfg,
+	}, nil
+}
+
+// 替换限流计数的存储
+func (rl *RateLimiter) SetBackend(backend RateLimitBackend) {
+	rl.backend = backend
+}
+
+// 替换本地限流使用的时钟
+func (rl *RateLimiter) SetClock(clock Clock) {
+	rl.local.SetClock(clock)
+}
+
+// 关闭限流存储的连接
+func (rl *RateLimiter) Close() error {
+	if closer, ok := rl.backend.(io.Closer); ok {
+		return closer.Close()
 	}
+	return nil
+}
+
+// 本地跟踪的客户端数，包括全局与各路由的限流器
+func (rl *RateLimiter) TrackedKeys() int {
+	return rl.local.TrackedKeys()
+}
+
+// 判断规则是否允许请求通过，共享存储不可用时改用本地限流
+// 返回的函数退还本次计入的限额
+func (rl *RateLimiter) take(c *gin.Context, rule *rateLimitRule) (RateLimitResult, func()) {
+	var client string
+	if rule.key != "" {
+		client = clientKey(c, rule.key)
+	}
+
+	ctx := c.Request.Context()
+	backend := rl.backend
+	result, err := backend.Take(ctx, rule.name, client, rule.policy)
+	if err != nil {
+		backend = rl.local
+		result, _ = backend.Take(ctx, rule.name, client, rule.policy)
+	}
+	return result, func() {
+		backend.Refund(ctx, rule.name, client, rule.policy, result.Reservation)
+	}
+}
+
+// 路径是否需要限流，exempt 与 include 同时匹配时以较长的前缀为准
+func (rl *RateLimiter) applies(path string) bool {
+	exempt := longestPrefix(path, rl.exempt)
+	include := longestPrefix(path, rl.config.Include)
+	if include < 0 && len(rl.config.Include) > 0 {
+		return false
+	}
+	return exempt < include || exempt < 0
+}
+
+// 匹配的最长前缀的长度，没有匹配时返回 -1
+func longestPrefix(path string, prefixes []string) int {
+	longest := -1
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+		}
+	}
+	return longest
 }
 
 // Handle 限流中间件处理函数
+// 按用户或 API Key 限流时需在认证中间件之后执行
 func (rl *RateLimiter) Handle() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 如果未启用限流，则直接通过
-		if !rl.config.Enable {
+		// 如果未启用限流或路径不需要限流，则直接通过
+		if !rl.config.Enable || !rl.applies(c.Request.URL.Path) {
 			c.Next()
 			return
 		}
@@ -95,7 +257,7 @@ func (rl *RateLimiter) Handle() gin.HandlerFunc {
 		path := c.Request.URL.Path
 
 		// 优先检查特定路由的限流
-		var matchedLimiter *TokenBucket
+		var matchedLimiter *rateLimitRule
 		var longestMatch string
 
 		// 查找最长匹配的路由
@@ -106,22 +268,54 @@ func (rl *RateLimiter) Handle() gin.HandlerFunc {
 			}
 		}
 
-		// 应用特定路由限流
+		rules := []*rateLimitRule{rl.globalLimiter}
 		if matchedLimiter != nil {
-			if !matchedLimiter.Allow() {
-				c.JSON(429, gin.H{"error": "too many requests"})
-				c.Abort()
-				return
-			}
+			rules = []*rateLimitRule{matchedLimiter, rl.globalLimiter}
 		}
 
-		// 应用全局限流
-		if !rl.globalLimiter.Allow() {
-			c.JSON(429, gin.H{"error": "too many requests"})
-			c.Abort()
-			return
+		// 依次在所有匹配的限流器上预留限额，任一限流器拒绝时退还已预留的限额
+		// 响应头反映剩余请求数最少的限流器
+		var tightest RateLimitResult
+		var refunds []func()
+		for i, rule := range rules {
+			result, refund := rl.take(c, rule)
+			if !result.Allowed {
+				for _, refund := range refunds {
+					refund()
+				}
+				rejectRateLimited(c, rule, result)
+				return
+			}
+			refunds = append(refunds, refund)
+			if i == 0 || result.Remaining < tightest.Remaining {
+				tightest = result
+			}
 		}
+		setRateLimitHeaders(c, tightest)
 
 		c.Next()
 	}
 }
+
+// 设置限流响应头 (draft-ietf-httpapi-ratelimit-headers)，时间以秒为单位并向上取整
+func setRateLimitHeaders(c *gin.Context, result RateLimitResult) {
+	h := c.Writer.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+}
+
+// 返回 429，X-RateLimit-Scope 指明拒绝请求的限流器：global 或 route:<路由>
+func rejectRateLimited(c *gin.Context, rule *rateLimitRule, result RateLimitResult) {
+	setRateLimitHeaders(c, result)
+	if result.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+	}
+	c.Header("X-RateLimit-Scope", rule.name)
+	abortWithCode(c, 429, codeRateLimited, "too many requests")
+}
+
+// 向上取整的秒数
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
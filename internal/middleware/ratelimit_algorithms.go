@@ -0,0 +1,334 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 时钟，测试时可替换为手动推进的时钟
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// 限流算法，每次调用 Take 判断是否允许一个请求，允许时计入限额
+type Limiter interface {
+	Take() RateLimitResult
+	// 退还一个已通过请求计入的限额，用于同一请求被其他限流器拒绝时
+	// reservation 为该请求 Take 返回的 Reservation
+	Refund(reservation string)
+}
+
+// 一次限流判断的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int           // 限额，令牌桶与 GCRA 为 burst，窗口类算法为 limit
+	Remaining  int           // 剩余可用的请求数
+	Reset      time.Duration // 距离限额完全恢复的时间
+	RetryAfter time.Duration // 请求被拒绝时，距离允许下一个请求的时间
+	// 通过的请求在限流器中的记录，退还时只撤销这条记录，不需要区分请求的算法为空
+	Reservation string
+}
+
+// 秒数转换为时长
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// 限流规则的参数
+type RateLimitPolicy struct {
+	Algorithm string        // 限流算法，为空时使用令牌桶
+	Rate      int           // 每秒允许的请求数，用于令牌桶与 GCRA
+	Burst     int           // 突发流量的容量，用于令牌桶与 GCRA
+	Limit     int           // 每个窗口允许的请求数，用于窗口类算法
+	Window    time.Duration // 窗口长度，用于窗口类算法
+}
+
+// 检查参数是否满足算法的要求
+func (p RateLimitPolicy) validate() error {
+	switch p.Algorithm {
+	case "", config.RateLimitAlgorithmTokenBucket:
+		return nil
+	case config.RateLimitAlgorithmGCRA:
+		if p.Rate <= 0 || p.Burst <= 0 {
+			return errors.New("gcra requires positive rate and burst")
+		}
+		// 请求间隔精确到纳秒，速率更高时间隔为 0
+		if p.Rate > int(time.Second) {
+			return fmt.Errorf("gcra rate must not exceed %d", int(time.Second))
+		}
+		return nil
+	case config.RateLimitAlgorithmFixedWindow, config.RateLimitAlgorithmSlidingWindowLog, config.RateLimitAlgorithmSlidingWindowCounter:
+		if p.Limit <= 0 || p.Window < time.Millisecond {
+			return fmt.Errorf("%s requires positive limit and a window of at least 1ms", p.Algorithm)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported rate limit algorithm %q", p.Algorithm)
+	}
+}
+
+// 空闲超过该时间的限流器与新建的等价
+func (p RateLimitPolicy) idleTTL() time.Duration {
+	switch p.Algorithm {
+	case config.RateLimitAlgorithmFixedWindow, config.RateLimitAlgorithmSlidingWindowLog:
+		return p.Window
+	case config.RateLimitAlgorithmSlidingWindowCounter:
+		return 2 * p.Window
+	default:
+		if p.Rate <= 0 {
+			return time.Duration(math.MaxInt64)
+		}
+		return time.Duration(float64(p.Burst) / float64(p.Rate) * float64(time.Second))
+	}
+}
+
+// 按规则参数创建限流器
+func NewLimiter(policy RateLimitPolicy, clock Clock) (Limiter, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return newLimiter(policy, clock), nil
+}
+
+// 创建限流器，参数需已通过检查
+func newLimiter(policy RateLimitPolicy, clock Clock) Limiter {
+	switch policy.Algorithm {
+	case config.RateLimitAlgorithmGCRA:
+		return &gcraLimiter{clock: clock, period: time.Second / time.Duration(policy.Rate), burst: policy.Burst}
+	case config.RateLimitAlgorithmFixedWindow:
+		return &fixedWindowLimiter{clock: clock, limit: policy.Limit, window: policy.Window}
+	case config.RateLimitAlgorithmSlidingWindowLog:
+		return &slidingWindowLogLimiter{clock: clock, limit: policy.Limit, window: policy.Window}
+	case config.RateLimitAlgorithmSlidingWindowCounter:
+		return &slidingWindowCounterLimiter{clock: clock, limit: policy.Limit, window: policy.Window}
+	default:
+		return newTokenBucket(policy.Rate, policy.Burst, clock)
+	}
+}
+
+// 通用信元速率算法，记录理论到达时间 (TAT)，请求按 period 均匀间隔，最多提前 burst 个
+type gcraLimiter struct {
+	mu     sync.Mutex
+	clock  Clock
+	period time.Duration // 两个请求的理论间隔
+	burst  int
+	tat    time.Time
+}
+
+func (l *gcraLimiter) Take() RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	tolerance := time.Duration(l.burst) * l.period
+	result := RateLimitResult{Limit: l.burst}
+
+	next := tat.Add(l.period)
+	if next.Sub(now) > tolerance {
+		result.RetryAfter = next.Sub(now) - tolerance
+	} else {
+		tat = next
+		l.tat = next
+		result.Allowed = true
+	}
+	result.Reset = tat.Sub(now)
+	result.Remaining = int((tolerance - result.Reset) / l.period)
+	return result
+}
+
+func (l *gcraLimiter) Refund(string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tat = l.tat.Add(-l.period)
+}
+
+// 固定窗口，窗口按 window 的整数倍对齐，如 24h 的窗口从 UTC 零点开始
+type fixedWindowLimiter struct {
+	mu     sync.Mutex
+	clock  Clock
+	limit  int
+	window time.Duration
+	start  time.Time
+	count  int
+}
+
+func (l *fixedWindowLimiter) Take() RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	start := now.Truncate(l.window)
+	if !start.Equal(l.start) {
+		l.start = start
+		l.count = 0
+	}
+
+	result := RateLimitResult{Limit: l.limit, Reset: start.Add(l.window).Sub(now)}
+	if l.count >= l.limit {
+		result.RetryAfter = result.Reset
+		return result
+	}
+	l.count++
+	result.Allowed = true
+	result.Remaining = l.limit - l.count
+	return result
+}
+
+// 窗口已切换时不需要退还
+func (l *fixedWindowLimiter) Refund(string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count > 0 && l.clock.Now().Truncate(l.window).Equal(l.start) {
+		l.count--
+	}
+}
+
+// 滑动窗口日志，保存窗口内通过的请求时间
+type slidingWindowLogLimiter struct {
+	mu     sync.Mutex
+	clock  Clock
+	limit  int
+	window time.Duration
+	log    []logEntry // 按时间排序
+	nextID uint64
+}
+
+// 滑动窗口日志中的一条记录，id 用于退还时找到对应的请求
+type logEntry struct {
+	at time.Time
+	id uint64
+}
+
+func (l *slidingWindowLogLimiter) Take() RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	cutoff := now.Add(-l.window)
+	expired := 0
+	for expired < len(l.log) && !l.log[expired].at.After(cutoff) {
+		expired++
+	}
+	l.log = l.log[expired:]
+
+	result := RateLimitResult{Limit: l.limit}
+	if len(l.log) >= l.limit {
+		// 最早的请求移出窗口后才能通过
+		result.RetryAfter = l.log[len(l.log)-l.limit].at.Add(l.window).Sub(now)
+	} else {
+		l.nextID++
+		l.log = append(l.log, logEntry{at: now, id: l.nextID})
+		result.Allowed = true
+		result.Reservation = strconv.FormatUint(l.nextID, 10)
+	}
+	result.Remaining = l.limit - len(l.log)
+	if len(l.log) > 0 {
+		result.Reset = l.log[len(l.log)-1].at.Add(l.window).Sub(now)
+	}
+	return result
+}
+
+// 移除预留的记录，记录已移出窗口时不需要退还
+func (l *slidingWindowLogLimiter) Refund(reservation string) {
+	id, err := strconv.ParseUint(reservation, 10, 64)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, entry := range l.log {
+		if entry.id == id {
+			l.log = append(l.log[:i], l.log[i+1:]...)
+			return
+		}
+	}
+}
+
+// 滑动窗口计数，上一窗口的计数按与当前时间的重叠比例计入
+type slidingWindowCounterLimiter struct {
+	mu       sync.Mutex
+	clock    Clock
+	limit    int
+	window   time.Duration
+	start    time.Time // 当前窗口的开始时间
+	current  int
+	previous int
+}
+
+func (l *slidingWindowCounterLimiter) Take() RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	start := now.Truncate(l.window)
+	switch {
+	case start.Equal(l.start):
+	case start.Sub(l.start) == l.window:
+		l.previous, l.current = l.current, 0
+	default:
+		l.previous, l.current = 0, 0
+	}
+	l.start = start
+
+	result := RateLimitResult{Limit: l.limit}
+	weight := 1 - float64(now.Sub(start))/float64(l.window)
+	estimate := float64(l.previous)*weight + float64(l.current)
+	if estimate+1 > float64(l.limit) {
+		result.RetryAfter = l.retryAfter(now)
+	} else {
+		l.current++
+		estimate++
+		result.Allowed = true
+	}
+	result.Remaining = int(math.Max(0, float64(l.limit)-estimate))
+
+	switch {
+	case l.current > 0:
+		result.Reset = start.Add(2 * l.window).Sub(now)
+	case l.previous > 0:
+		result.Reset = start.Add(l.window).Sub(now)
+	}
+	return result
+}
+
+// 窗口已切换时不需要退还
+func (l *slidingWindowCounterLimiter) Refund(string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current > 0 && l.clock.Now().Truncate(l.window).Equal(l.start) {
+		l.current--
+	}
+}
+
+// 距离估算值允许下一个请求的时间
+func (l *slidingWindowCounterLimiter) retryAfter(now time.Time) time.Duration {
+	limit := float64(l.limit)
+
+	// 当前窗口内随上一窗口的权重降低而允许
+	if l.current+1 <= l.limit {
+		weight := (limit - float64(l.current) - 1) / float64(l.previous)
+		return l.start.Add(time.Duration((1 - weight) * float64(l.window))).Sub(now)
+	}
+
+	// 当前窗口的计数成为下一窗口的上一窗口计数
+	next := l.start.Add(l.window)
+	if weight := (limit - 1) / float64(l.current); weight < 1 {
+		next = next.Add(time.Duration((1 - weight) * float64(l.window)))
+	}
+	return next.Sub(now)
+}
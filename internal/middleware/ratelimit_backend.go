@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+)
+
+// 限流计数的存储，多副本部署时可替换为共享存储，使限额在所有实例间生效
+type RateLimitBackend interface {
+	// 判断规则 rule 是否允许客户端 client 的一个请求，client 为空表示规则内的请求共享限额
+	// 同一规则的 policy 不变
+	Take(ctx context.Context, rule, client string, policy RateLimitPolicy) (RateLimitResult, error)
+	// 退还一个已通过请求计入的限额，reservation 为该请求 Take 返回的 Reservation
+	Refund(ctx context.Context, rule, client string, policy RateLimitPolicy, reservation string) error
+}
+
+// 基于内存的限流存储，只在单个网关实例内生效
+// 每条规则的客户端限流器数量不超过 maxKeys
+type MemoryRateLimitBackend struct {
+	maxKeys int
+
+	mu      sync.Mutex
+	clock   Clock
+	shared  map[string]Limiter
+	clients map[string]*limiterCache
+}
+
+// 创建基于内存的限流存储
+func NewMemoryRateLimitBackend(maxKeys int) *MemoryRateLimitBackend {
+	if maxKeys <= 0 {
+		maxKeys = defaultRateLimitMaxKeys
+	}
+	return &MemoryRateLimitBackend{
+		maxKeys: maxKeys,
+		clock:   systemClock{},
+		shared:  make(map[string]Limiter),
+		clients: make(map[string]*limiterCache),
+	}
+}
+
+// 替换时钟，只影响之后创建的限流器
+func (b *MemoryRateLimitBackend) SetClock(clock Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock = clock
+}
+
+// 判断是否允许一个请求
+func (b *MemoryRateLimitBackend) Take(ctx context.Context, rule, client string, policy RateLimitPolicy) (RateLimitResult, error) {
+	return b.limiter(rule, client, policy).Take(), nil
+}
+
+// 退还一个已通过请求计入的限额
+func (b *MemoryRateLimitBackend) Refund(ctx context.Context, rule, client string, policy RateLimitPolicy, reservation string) error {
+	b.limiter(rule, client, policy).Refund(reservation)
+	return nil
+}
+
+// 规则与客户端对应的限流器，不存在时创建
+func (b *MemoryRateLimitBackend) limiter(rule, client string, policy RateLimitPolicy) Limiter {
+	b.mu.Lock()
+	if client == "" {
+		limiter, ok := b.shared[rule]
+		if !ok {
+			limiter = newLimiter(policy, b.clock)
+			b.shared[rule] = limiter
+		}
+		b.mu.Unlock()
+		return limiter
+	}
+
+	cache, ok := b.clients[rule]
+	if !ok {
+		cache = newLimiterCache(policy, b.clock, b.maxKeys)
+		b.clients[rule] = cache
+	}
+	b.mu.Unlock()
+	return cache.get(client)
+}
+
+// 当前跟踪的客户端数，不包括共享限额的限流器
+func (b *MemoryRateLimitBackend) TrackedKeys() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total := 0
+	for _, cache := range b.clients {
+		total += cache.len()
+	}
+	return total
+}
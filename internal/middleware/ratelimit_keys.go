@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 默认最多跟踪的客户端数
+const defaultRateLimitMaxKeys = 10000
+
+// 按客户端保存的限流器，超过容量时淘汰最久未使用的
+// 空闲到与新建时等价的限流器同样会被清理
+type limiterCache struct {
+	policy  RateLimitPolicy
+	clock   Clock
+	maxKeys int
+	idleTTL time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List // 按最近使用排序，队首最新
+	items map[string]*list.Element
+}
+
+type limiterEntry struct {
+	key      string
+	limiter  Limiter
+	lastUsed time.Time
+}
+
+func newLimiterCache(policy RateLimitPolicy, clock Clock, maxKeys int) *limiterCache {
+	return &limiterCache{
+		policy:  policy,
+		clock:   clock,
+		maxKeys: maxKeys,
+		idleTTL: policy.idleTTL(),
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// 获取客户端的限流器，不存在时创建
+func (lc *limiterCache) get(key string) Limiter {
+	now := lc.clock.Now()
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if el, ok := lc.items[key]; ok {
+		entry := el.Value.(*limiterEntry)
+		entry.lastUsed = now
+		lc.ll.MoveToFront(el)
+		return entry.limiter
+	}
+
+	// 从最久未使用的一端清理空闲的限流器，并为新限流器腾出空间
+	for el := lc.ll.Back(); el != nil; el = lc.ll.Back() {
+		entry := el.Value.(*limiterEntry)
+		if lc.ll.Len() < lc.maxKeys && now.Sub(entry.lastUsed) < lc.idleTTL {
+			break
+		}
+		lc.ll.Remove(el)
+		delete(lc.items, entry.key)
+	}
+
+	entry := &limiterEntry{key: key, limiter: newLimiter(lc.policy, lc.clock), lastUsed: now}
+	lc.items[key] = lc.ll.PushFront(entry)
+	return entry.limiter
+}
+
+// 当前跟踪的客户端数
+func (lc *limiterCache) len() int {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.ll.Len()
+}
+
+func validateRateLimitKey(key string) error {
+	switch {
+	case key == "", key == config.RateLimitKeyIP, key == config.RateLimitKeyUser, key == config.RateLimitKeyAPIKey:
+		return nil
+	case strings.HasPrefix(key, config.RateLimitKeyHeaderPrefix) && len(key) > len(config.RateLimitKeyHeaderPrefix):
+		return nil
+	default:
+		return fmt.Errorf("unsupported rate limit key %q", key)
+	}
+}
+
+// 计算请求的客户端 key，无法取得时使用客户端 IP
+// key 带有类型前缀，避免不同来源的值相互冲突
+func clientKey(c *gin.Context, key string) string {
+	switch {
+	case key == config.RateLimitKeyUser:
+		if subject := requestSubject(c); subject != "" {
+			return "user:" + subject
+		}
+	case key == config.RateLimitKeyAPIKey:
+		if consumer, ok := c.Get("consumer"); ok {
+			return "apiKey:" + consumer.(*auth.Consumer).Name
+		}
+	case strings.HasPrefix(key, config.RateLimitKeyHeaderPrefix):
+		if value := c.GetHeader(strings.TrimPrefix(key, config.RateLimitKeyHeaderPrefix)); value != "" {
+			return "header:" + value
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
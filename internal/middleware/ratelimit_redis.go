@@ -0,0 +1,362 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis 限流存储的默认参数
+const (
+	defaultRedisKeyPrefix         = "gogate:ratelimit:"
+	defaultRedisTimeout           = 200 * time.Millisecond
+	defaultRateLimitRetryInterval = 5 * time.Second
+)
+
+// 共享存储不可用，调用方应改用本地限流
+var ErrRateLimitBackendUnavailable = errors.New("rate limit backend unavailable")
+
+// 限流脚本在 Redis 内原子地读取与更新计数
+// 返回 {是否允许, 剩余请求数, 距离限额完全恢复的毫秒数, 距离允许下一个请求的毫秒数}
+// 使用 Redis 的时钟 (毫秒)，避免各网关实例的时钟偏差影响计数
+const redisNow = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// 令牌桶，ARGV[1] 每秒生成的令牌数，ARGV[2] 桶的容量
+var tokenBucketScript = redis.NewScript(redisNow + `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+elseif rate > 0 then
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+-- 令牌补满后的桶与新建的桶等价，可以删除
+local reset = 0
+if rate > 0 then
+	reset = math.ceil((burst - tokens) / rate * 1000)
+	redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+end
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+// GCRA，ARGV[1] 两个请求的理论间隔 (毫秒)，ARGV[2] 最多提前的请求数
+var gcraScript = redis.NewScript(redisNow + `
+local period = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local tolerance = burst * period
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+
+local next = tat + period
+if next - now > tolerance then
+	return {0, math.floor((tolerance - (tat - now)) / period + 1e-9), math.ceil(tat - now), math.ceil(next - now - tolerance)}
+end
+redis.call('SET', KEYS[1], tostring(next), 'PX', math.ceil(next - now) + 1000)
+return {1, math.floor((tolerance - (next - now)) / period + 1e-9), math.ceil(next - now), 0}
+`)
+
+// 固定窗口，ARGV[1] 窗口长度 (毫秒)，ARGV[2] 每个窗口允许的请求数
+var fixedWindowScript = redis.NewScript(redisNow + `
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local start = now - now % window
+local reset = start + window - now
+local state = redis.call('HMGET', KEYS[1], 'start', 'count')
+local count = 0
+if tonumber(state[1]) == start then
+	count = tonumber(state[2])
+end
+
+if count >= limit then
+	return {0, 0, reset, reset}
+end
+redis.call('HSET', KEYS[1], 'start', start, 'count', count + 1)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {1, limit - count - 1, reset, 0}
+`)
+
+// 滑动窗口日志，ARGV[1] 窗口长度 (毫秒)，ARGV[2] 窗口内允许的请求数，ARGV[3] 本次请求的记录，退还时按其删除
+var slidingWindowLogScript = redis.NewScript(redisNow + `
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+	-- 最早的请求移出窗口后才能通过
+	local oldest = redis.call('ZRANGE', KEYS[1], count - limit, count - limit, 'WITHSCORES')
+	local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	return {0, 0, tonumber(newest[2]) + window - now, tonumber(oldest[2]) + window - now}
+end
+redis.call('ZADD', KEYS[1], now, ARGV[3])
+redis.call('PEXPIRE', KEYS[1], window + 1000)
+return {1, limit - count - 1, window, 0}
+`)
+
+// 滑动窗口计数，ARGV[1] 窗口长度 (毫秒)，ARGV[2] 窗口内允许的请求数
+var slidingWindowCounterScript = redis.NewScript(redisNow + `
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local start = now - now % window
+local state = redis.call('HMGET', KEYS[1], 'start', 'current', 'previous')
+local last = tonumber(state[1])
+local current = 0
+local previous = 0
+if last == start then
+	current = tonumber(state[2])
+	previous = tonumber(state[3])
+elseif last == start - window then
+	previous = tonumber(state[2])
+end
+
+local allowed = 0
+local retry = 0
+local estimate = previous * (1 - (now - start) / window) + current
+if estimate + 1 > limit then
+	local at
+	if current + 1 <= limit then
+		-- 当前窗口内随上一窗口的权重降低而允许
+		at = start + (1 - (limit - current - 1) / previous) * window
+	else
+		-- 当前窗口的计数成为下一窗口的上一窗口计数
+		at = start + window
+		local weight = (limit - 1) / current
+		if weight < 1 then
+			at = at + (1 - weight) * window
+		end
+	end
+	retry = math.ceil(at - now)
+else
+	current = current + 1
+	estimate = estimate + 1
+	allowed = 1
+	redis.call('HSET', KEYS[1], 'start', start, 'current', current, 'previous', previous)
+	redis.call('PEXPIRE', KEYS[1], start + 2 * window - now + 1000)
+end
+
+local reset = 0
+if current > 0 then
+	reset = start + 2 * window - now
+elseif previous > 0 then
+	reset = start + window - now
+end
+return {allowed, math.floor(math.max(0, limit - estimate) + 1e-9), reset, retry}
+`)
+
+// 退还一个已通过请求计入的限额，ARGV[1] 限流算法，ARGV[2] 令牌桶的容量、GCRA 的理论间隔或窗口长度
+// ARGV[3] 滑动窗口日志中该请求的记录
+// 修改已有的 key 时保留原来的过期时间，窗口已切换时不需要退还
+var refundScript = redis.NewScript(redisNow + `
+local algorithm = ARGV[1]
+if algorithm == 'tokenBucket' then
+	local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+	if tokens ~= nil then
+		redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(tonumber(ARGV[2]), tokens + 1)))
+	end
+elseif algorithm == 'gcra' then
+	if redis.call('EXISTS', KEYS[1]) == 1 then
+		redis.call('INCRBYFLOAT', KEYS[1], -tonumber(ARGV[2]))
+	end
+elseif algorithm == 'slidingWindowLog' then
+	redis.call('ZREM', KEYS[1], ARGV[3])
+else
+	local window = tonumber(ARGV[2])
+	local field = 'count'
+	if algorithm == 'slidingWindowCounter' then
+		field = 'current'
+	end
+	local state = redis.call('HMGET', KEYS[1], 'start', field)
+	if tonumber(state[1]) == now - now % window and (tonumber(state[2]) or 0) > 0 then
+		redis.call('HINCRBY', KEYS[1], field, -1)
+	end
+end
+return 1
+`)
+
+// 基于 Redis 的限流存储，所有网关实例共享计数
+// Redis 不可用时在 retryInterval 内直接返回 ErrRateLimitBackendUnavailable，不再等待超时
+type RedisRateLimitBackend struct {
+	client        *redis.Client
+	prefix        string
+	retryInterval time.Duration
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// 创建基于 Redis 的限流存储
+func NewRedisRateLimitBackend(cfg config.RedisConfig, retryInterval time.Duration) (*RedisRateLimitBackend, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("redis addr is required")
+	}
+
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	if retryInterval <= 0 {
+		retryInterval = defaultRateLimitRetryInterval
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Addr,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		MaxRetries:   -1, // 限流在请求路径上，失败时直接改用本地限流
+	})
+
+	return &RedisRateLimitBackend{
+		client:        client,
+		prefix:        prefix,
+		retryInterval: retryInterval,
+	}, nil
+}
+
+// 判断是否允许一个请求
+func (b *RedisRateLimitBackend) Take(ctx context.Context, rule, client string, policy RateLimitPolicy) (RateLimitResult, error) {
+	if !b.available() {
+		return RateLimitResult{}, ErrRateLimitBackendUnavailable
+	}
+
+	script, args, reservation := redisScript(policy)
+	reply, err := script.Run(ctx, b.client, []string{b.key(rule, client)}, args...).Int64Slice()
+	if err == nil && len(reply) != 4 {
+		err = fmt.Errorf("unexpected script reply %v", reply)
+	}
+	if err != nil {
+		return RateLimitResult{}, b.failed(ctx, err)
+	}
+
+	limit := policy.Limit
+	switch policy.Algorithm {
+	case "", config.RateLimitAlgorithmTokenBucket, config.RateLimitAlgorithmGCRA:
+		limit = policy.Burst
+	}
+	result := RateLimitResult{
+		Allowed:    reply[0] == 1,
+		Limit:      limit,
+		Remaining:  int(reply[1]),
+		Reset:      time.Duration(reply[2]) * time.Millisecond,
+		RetryAfter: time.Duration(reply[3]) * time.Millisecond,
+	}
+	if result.Allowed {
+		result.Reservation = reservation
+	}
+	return result, nil
+}
+
+// 退还一个已通过请求计入的限额
+func (b *RedisRateLimitBackend) Refund(ctx context.Context, rule, client string, policy RateLimitPolicy, reservation string) error {
+	if !b.available() {
+		return ErrRateLimitBackendUnavailable
+	}
+
+	var arg interface{}
+	algorithm := policy.Algorithm
+	switch algorithm {
+	case "", config.RateLimitAlgorithmTokenBucket:
+		algorithm = config.RateLimitAlgorithmTokenBucket
+		arg = policy.Burst
+	case config.RateLimitAlgorithmGCRA:
+		arg = float64(time.Second/time.Millisecond) / float64(policy.Rate)
+	default:
+		arg = policy.Window.Milliseconds()
+	}
+
+	if err := refundScript.Run(ctx, b.client, []string{b.key(rule, client)}, algorithm, arg, reservation).Err(); err != nil {
+		return b.failed(ctx, err)
+	}
+	return nil
+}
+
+// 规则与客户端对应的 key
+func (b *RedisRateLimitBackend) key(rule, client string) string {
+	if client == "" {
+		return b.prefix + rule
+	}
+	return b.prefix + rule + ":" + client
+}
+
+// 记录调用失败，客户端断开导致的失败不代表 Redis 不可用
+func (b *RedisRateLimitBackend) failed(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		b.markDown(err)
+	}
+	return fmt.Errorf("%w: %v", ErrRateLimitBackendUnavailable, err)
+}
+
+// 算法对应的脚本与参数，以及请求通过时的记录
+func redisScript(policy RateLimitPolicy) (*redis.Script, []interface{}, string) {
+	window := policy.Window.Milliseconds()
+	switch policy.Algorithm {
+	case config.RateLimitAlgorithmGCRA:
+		period := float64(time.Second/time.Millisecond) / float64(policy.Rate)
+		return gcraScript, []interface{}{period, policy.Burst}, ""
+	case config.RateLimitAlgorithmFixedWindow:
+		return fixedWindowScript, []interface{}{window, policy.Limit}, ""
+	case config.RateLimitAlgorithmSlidingWindowLog:
+		// 随机的记录区分同一毫秒内的请求
+		member := newTokenID()
+		return slidingWindowLogScript, []interface{}{window, policy.Limit, member}, member
+	case config.RateLimitAlgorithmSlidingWindowCounter:
+		return slidingWindowCounterScript, []interface{}{window, policy.Limit}, ""
+	default:
+		return tokenBucketScript, []interface{}{policy.Rate, policy.Burst}, ""
+	}
+}
+
+// 关闭 Redis 连接
+func (b *RedisRateLimitBackend) Close() error {
+	return b.client.Close()
+}
+
+func (b *RedisRateLimitBackend) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.downUntil)
+}
+
+// 记录 Redis 不可用，retryInterval 后再尝试
+func (b *RedisRateLimitBackend) markDown(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.downUntil) {
+		return
+	}
+	b.downUntil = now.Add(b.retryInterval)
+	log.Printf("Rate limit backend unavailable, falling back to local limits for %s: %v", b.retryInterval, err)
+}
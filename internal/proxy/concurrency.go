@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 自适应并发限制的默认值
+const (
+	defaultConcurrencyInitialLimit     = 20
+	defaultConcurrencyMinLimit         = 1
+	defaultConcurrencyMaxLimit         = 200
+	defaultConcurrencyLatencyThreshold = time.Second
+	defaultConcurrencyBackoffRatio     = 0.9
+	defaultConcurrencyQueueTimeout     = 50 * time.Millisecond
+)
+
+// 一次请求的结果，用于调整并发上限
+type concurrencySample int
+
+const (
+	sampleIgnored    concurrencySample = iota // 客户端取消等与上游无关的结果
+	sampleOK                                  // 上游及时响应
+	sampleOverloaded                          // 上游响应慢、超时或返回 502/503/504
+)
+
+// 自适应并发限制 (AIMD)
+// 上游及时响应且并发达到上限的一半时上限加一，过载时上限按 backoffRatio 降低
+type concurrencyLimiter struct {
+	mu           sync.Mutex
+	limit        int
+	minLimit     int
+	maxLimit     int
+	threshold    time.Duration
+	backoffRatio float64
+	queueSize    int // 0 表示排队数等于当前上限，-1 表示不排队
+	queueTimeout time.Duration
+	inflight     int
+	waiters      *list.List // 排队的请求，元素为获得名额时关闭的 chan struct{}
+	lastBackoff  time.Time  // 上次降低上限的时间，此前开始的请求不再触发降低
+}
+
+// 创建并发限制，未设置的参数使用默认值
+func newConcurrencyLimiter(cfg config.ConcurrencyConfig) (*concurrencyLimiter, error) {
+	l := &concurrencyLimiter{
+		limit:        cfg.InitialLimit,
+		minLimit:     cfg.MinLimit,
+		maxLimit:     cfg.MaxLimit,
+		threshold:    cfg.LatencyThreshold,
+		backoffRatio: cfg.BackoffRatio,
+		queueSize:    cfg.QueueSize,
+		queueTimeout: cfg.QueueTimeout,
+		waiters:      list.New(),
+	}
+	if l.limit == 0 {
+		l.limit = defaultConcurrencyInitialLimit
+	}
+	if l.minLimit == 0 {
+		l.minLimit = defaultConcurrencyMinLimit
+	}
+	if l.maxLimit == 0 {
+		l.maxLimit = defaultConcurrencyMaxLimit
+	}
+	if l.threshold == 0 {
+		l.threshold = defaultConcurrencyLatencyThreshold
+	}
+	if l.backoffRatio == 0 {
+		l.backoffRatio = defaultConcurrencyBackoffRatio
+	}
+	if l.queueTimeout == 0 {
+		l.queueTimeout = defaultConcurrencyQueueTimeout
+	}
+
+	if l.minLimit < 1 || l.minLimit > l.limit || l.limit > l.maxLimit {
+		return nil, errors.New("concurrency limits must satisfy 1 <= minLimit <= initialLimit <= maxLimit")
+	}
+	if l.backoffRatio <= 0 || l.backoffRatio >= 1 {
+		return nil, errors.New("concurrency backoffRatio must be between 0 and 1")
+	}
+	if l.queueSize < -1 {
+		return nil, errors.New("concurrency queueSize must be -1 (no queue), 0 (current limit) or positive")
+	}
+	if l.threshold < 0 || l.queueTimeout < 0 {
+		return nil, errors.New("concurrency latencyThreshold and queueTimeout must not be negative")
+	}
+	return l, nil
+}
+
+// 当前的并发上限
+func (l *concurrencyLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// 最多排队的请求数，调用方需持有锁
+func (l *concurrencyLimiter) maxWaiters() int {
+	switch {
+	case l.queueSize < 0:
+		return 0
+	case l.queueSize == 0:
+		return l.limit
+	default:
+		return l.queueSize
+	}
+}
+
+// 占用一个并发名额，达到上限时最多排队 queueTimeout，返回 false 表示丢弃请求
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	l.mu.Lock()
+	if l.inflight < l.limit {
+		l.inflight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.waiters.Len() >= l.maxWaiters() {
+		l.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// 超时的同时已获得名额
+		return true
+	default:
+		l.waiters.Remove(elem)
+		return false
+	}
+}
+
+// 释放名额并按请求的结果调整上限，start 为请求占用名额的时间
+func (l *concurrencyLimiter) release(start time.Time, sample concurrencySample) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch sample {
+	case sampleOverloaded:
+		// 上限降低前已在处理的请求反映的是降低前的负载，不重复降低
+		if start.After(l.lastBackoff) {
+			l.limit = max(l.minLimit, int(float64(l.limit)*l.backoffRatio))
+			l.lastBackoff = time.Now()
+		}
+	case sampleOK:
+		// 并发远低于上限时响应快不能说明可以承受更高的并发
+		if l.inflight*2 >= l.limit && l.limit < l.maxLimit {
+			l.limit++
+		}
+	}
+	l.inflight--
+
+	// 按排队顺序分配空出的名额
+	for l.inflight < l.limit && l.waiters.Len() > 0 {
+		ready := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		close(ready)
+		l.inflight++
+	}
+}
+
+// 根据响应状态码与响应头到达的时间判断上游是否过载
+// r 为客户端的原始请求，其 context 只会因客户端断开而取消
+func (l *concurrencyLimiter) sample(r *http.Request, w *latencyWriter) concurrencySample {
+	if w.stalled.Load() {
+		return sampleOverloaded
+	}
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		return sampleIgnored
+	}
+	switch w.status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return sampleOverloaded
+	case 0:
+		return sampleIgnored
+	}
+	if w.latency > l.threshold {
+		return sampleOverloaded
+	}
+	return sampleOK
+}
+
+// 记录响应头到达时间与状态码的 ResponseWriter
+type latencyWriter struct {
+	http.ResponseWriter
+	start   time.Time
+	latency time.Duration
+	status  int
+	stalled atomic.Bool // 响应空闲超时，上游停止发送数据
+}
+
+func newLatencyWriter(w http.ResponseWriter, start time.Time) *latencyWriter {
+	return &latencyWriter{ResponseWriter: w, start: start}
+}
+
+func (w *latencyWriter) WriteHeader(code int) {
+	// 忽略 1xx 的中间响应
+	if w.status == 0 && code >= 200 {
+		w.status = code
+		w.latency = time.Since(w.start)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *latencyWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *latencyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *latencyWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
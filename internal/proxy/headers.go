@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// 转发到上游时需要调整的请求头，由认证等中间件计算后放入请求上下文
+type forwardHeaders struct {
+	strip []string    // 需要移除的客户端请求头，防止伪造
+	set   http.Header // 需要注入的请求头
+}
+
+type forwardHeadersKey struct{}
+
+// 设置转发到上游时移除与注入的请求头，在 Director 中生效
+// 多次调用时后设置的同名请求头覆盖先前的值
+func WithForwardHeaders(r *http.Request, strip []string, set http.Header) *http.Request {
+	fh := forwardHeaders{set: make(http.Header)}
+	if prev, ok := r.Context().Value(forwardHeadersKey{}).(forwardHeaders); ok {
+		fh.strip = append(fh.strip, prev.strip...)
+		for name, values := range prev.set {
+			fh.set[name] = values
+		}
+	}
+
+	fh.strip = append(fh.strip, strip...)
+	for name, values := range set {
+		fh.set[name] = values
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), forwardHeadersKey{}, fh))
+}
+
+// 应用请求上下文中的请求头调整
+func applyForwardHeaders(req *http.Request) {
+	fh, ok := req.Context().Value(forwardHeadersKey{}).(forwardHeaders)
+	if !ok {
+		return
+	}
+
+	for _, name := range fh.strip {
+		req.Header.Del(name)
+	}
+	for name, values := range fh.set {
+		req.Header[name] = values
+	}
+}
@@ -1,55 +1,134 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ilukemagic/gogate/internal/balancer"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/grpcutil"
 )
 
 // 封装反向代理的基本功能
 type ReverseProxy struct {
-	balancer *balancer.WeightedRoundRobin
-	proxies  map[string]*httputil.ReverseProxy
+	balancer  *balancer.WeightedRoundRobin
+	upstreams map[string]*upstream
+	grpc      bool // 是否为 gRPC 路由
+
+	timeout     time.Duration // 请求总超时，流式路由不生效
+	idleTimeout time.Duration // 响应空闲超时
+
+	// 自适应并发限制，未启用时为 nil
+	limiter *concurrencyLimiter
+
+	// WebSocket 长连接使用最少连接数均衡
+	wsBalancer *balancer.LeastConnections
+	wsConfig   config.WebSocketConfig
+	wsMu       sync.Mutex
+	wsSessions map[*wsSession]struct{}
+	wsActive   int // 包括握手中的连接
+	wsClosed   bool
+}
+
+// 单个上游目标
+type upstream struct {
+	url       *url.URL
+	proxy     *httputil.ReverseProxy
+	tlsConfig *tls.Config
 }
 
 // 创建反向代理实例
-func NewReverseProxy(targets []string, weights map[string]int) (*ReverseProxy, error) {
-	// 创建权重轮询负载均衡器
-	lb := balancer.NewWeightedRoundRobin(weights)
+func NewReverseProxy(route config.RouteConfig) (*ReverseProxy, error) {
+	// 转换配置为权重映射
+	weights := make(map[string]int)
+	for _, target := range route.Targets {
+		weights[target.URL] = target.Weight
+	}
+
+	// gRPC 基于 HTTP/2，必须使用 HTTP/2 连接上游
+	isGRPC := route.Protocol == config.ProtocolGRPC
+	useHTTP2 := route.HTTP2 || isGRPC
 
 	// 为每个目标创建代理
-	proxies := make(map[string]*httputil.ReverseProxy)
-	for _, target := range targets {
-		targetURL, err := url.Parse(target)
+	upstreams := make(map[string]*upstream)
+	for _, target := range route.Targets {
+		targetURL, err := url.Parse(target.URL)
 		if err != nil {
 			return nil, err
 		}
-		proxies[target] = httputil.NewSingleHostReverseProxy(targetURL)
+
+		// 目标级 TLS 配置优先于路由级配置
+		tlsCfg := route.TLS
+		if target.TLS != nil {
+			tlsCfg = target.TLS
+		}
+
+		tlsConfig, err := buildTLSConfig(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		proxy := newSingleTargetProxy(targetURL, newTransport(tlsConfig, useHTTP2, targetURL.Scheme))
+		proxy.FlushInterval = route.FlushInterval
+		proxy.ErrorHandler = errorHandler
+		if isGRPC {
+			// 流式 RPC 需要立即刷新每一帧
+			proxy.FlushInterval = -1
+			proxy.ErrorHandler = grpcErrorHandler
+		}
+		if route.Streaming {
+			proxy.FlushInterval = -1
+			proxy.ModifyResponse = disableBuffering
+		}
+
+		upstreams[target.URL] = &upstream{
+			url:       targetURL,
+			proxy:     proxy,
+			tlsConfig: tlsConfig,
+		}
+	}
+
+	var limiter *concurrencyLimiter
+	if route.Concurrency.Enable {
+		var err error
+		if limiter, err = newConcurrencyLimiter(route.Concurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	// 流式响应可能持续很久，只保留空闲超时
+	timeout := route.Timeout
+	if route.Streaming {
+		timeout = 0
 	}
 
 	return &ReverseProxy{
-		balancer: lb,
-		proxies:  proxies,
+		balancer:    balancer.NewWeightedRoundRobin(weights),
+		upstreams:   upstreams,
+		grpc:        isGRPC,
+		timeout:     timeout,
+		idleTimeout: route.IdleTimeout,
+		limiter:     limiter,
+		wsBalancer:  balancer.NewLeastConnections(weights),
+		wsConfig:    route.WebSocket,
+		wsSessions:  make(map[*wsSession]struct{}),
 	}, nil
 }
 
-// 实现 http.Handler 接口
-func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// 获取下一个目标服务器
-	target := p.balancer.Next()
-	if target == "" {
-		http.Error(w, "no available targets", http.StatusServiceUnavailable)
-		return
-	}
-
-	// 获取对应的代理
-	proxy := p.proxies[target]
+// 创建指向单个目标的代理
+func newSingleTargetProxy(targetURL *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy.Transport = transport
 
 	// 设置代理配置
-	targetURL, _ := url.Parse(target)
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
@@ -67,7 +146,112 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		req.Header.Set("X-Forwarded-Proto", req.URL.Scheme)
 		req.Header.Set("X-Forwarded-Host", req.Host)
 		req.Host = targetURL.Host
+
+		// 移除客户端伪造的身份头并注入认证得到的值
+		applyForwardHeaders(req)
 	}
 
-	proxy.ServeHTTP(w, r)
+	return proxy
+}
+
+// 当前的并发上限，未启用自适应并发限制时返回 0
+func (p *ReverseProxy) ConcurrencyLimit() int {
+	if p.limiter == nil {
+		return 0
+	}
+	return p.limiter.currentLimit()
+}
+
+// 上游错误处理，超时返回 504，其余返回 502
+func errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("proxy error: %v", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "upstream timeout", http.StatusGatewayTimeout)
+		return
+	}
+	http.Error(w, "upstream unavailable", http.StatusBadGateway)
+}
+
+// gRPC 路由的上游错误处理，返回 DEADLINE_EXCEEDED 或 UNAVAILABLE 状态
+func grpcErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("grpc proxy error: %v", err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		grpcutil.WriteError(w, http.StatusGatewayTimeout, "upstream timeout")
+		return
+	}
+	grpcutil.WriteError(w, http.StatusBadGateway, "upstream unavailable")
+}
+
+// 流式响应禁用前置代理 (如 nginx) 的缓冲
+func disableBuffering(resp *http.Response) error {
+	resp.Header.Set("X-Accel-Buffering", "no")
+	return nil
+}
+
+// 实现 http.Handler 接口
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// WebSocket 升级请求单独处理
+	if IsWebSocketRequest(r) {
+		p.serveWebSocket(w, r)
+		return
+	}
+
+	// 获取下一个目标服务器
+	target := p.balancer.Next()
+	if target == "" {
+		if p.grpc || grpcutil.IsGRPCRequest(r) {
+			grpcutil.WriteError(w, http.StatusServiceUnavailable, "no available targets")
+			return
+		}
+		http.Error(w, "no available targets", http.StatusServiceUnavailable)
+		return
+	}
+
+	// 并发达到上限且排队超时时丢弃请求，避免慢上游拖垮网关
+	// 调整上限时使用客户端的原始请求，以区分客户端取消与超时取消
+	var lw *latencyWriter
+	if p.limiter != nil {
+		if !p.limiter.acquire(r.Context()) {
+			if p.grpc || grpcutil.IsGRPCRequest(r) {
+				grpcutil.WriteError(w, http.StatusServiceUnavailable, "upstream overloaded")
+				return
+			}
+			http.Error(w, "upstream overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		orig := r
+		lw = newLatencyWriter(w, time.Now())
+		defer func() {
+			p.limiter.release(lw.start, p.limiter.sample(orig, lw))
+		}()
+		w = lw
+	}
+
+	// 请求总超时
+	if p.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	// 响应空闲超时：上游长时间没有数据时取消请求，并计为上游过载
+	if p.idleTimeout > 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		onIdle := cancel
+		if lw != nil {
+			onIdle = func() {
+				lw.stalled.Store(true)
+				cancel()
+			}
+		}
+		iw := newIdleTimeoutWriter(w, p.idleTimeout, onIdle)
+		defer iw.stop()
+		w = iw
+	}
+
+	// 获取对应的代理
+	p.upstreams[target].proxy.ServeHTTP(w, r)
 }
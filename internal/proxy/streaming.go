@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// 在每次写入时重置空闲计时的 ResponseWriter，超时后取消上游请求
+type idleTimeoutWriter struct {
+	http.ResponseWriter
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// 创建空闲超时 ResponseWriter，计时从创建时开始，也覆盖等待响应头的时间
+func newIdleTimeoutWriter(w http.ResponseWriter, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutWriter {
+	return &idleTimeoutWriter{
+		ResponseWriter: w,
+		timeout:        timeout,
+		timer:          time.AfterFunc(timeout, cancel),
+	}
+}
+
+func (w *idleTimeoutWriter) WriteHeader(code int) {
+	w.timer.Reset(w.timeout)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idleTimeoutWriter) Write(b []byte) (int, error) {
+	w.timer.Reset(w.timeout)
+	return w.ResponseWriter.Write(b)
+}
+
+// 支持 http.ResponseController 的 Flush 等操作
+func (w *idleTimeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *idleTimeoutWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// 停止计时
+func (w *idleTimeoutWriter) stop() {
+	w.timer.Stop()
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"golang.org/x/net/http2"
+)
+
+// 根据上游配置创建 Transport
+func newTransport(tlsConfig *tls.Config, useHTTP2 bool, scheme string) http.RoundTripper {
+	if useHTTP2 {
+		return newHTTP2Transport(tlsConfig, scheme)
+	}
+
+	// 默认 Transport 会通过 ALPN 自动协商 HTTPS 上游的 HTTP/2
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport
+}
+
+// 创建只使用 HTTP/2 的 Transport，明文目标使用 h2c (prior knowledge)
+func newHTTP2Transport(tlsConfig *tls.Config, scheme string) *http2.Transport {
+	transport := &http2.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	if scheme == "http" {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+
+	return transport
+}
+
+// 将配置转换为 tls.Config，未配置时返回 nil
+func buildTLSConfig(cfg *config.UpstreamTLSConfig) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	// 加载自定义 CA
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// 加载客户端证书 (mTLS)，证书和私钥必须同时配置
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("both certFile and keyFile are required for client certificates")
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
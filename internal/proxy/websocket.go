@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// WebSocket 操作码与关闭状态码
+const (
+	wsOpClose = 0x8
+
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+)
+
+// 64 位负载长度的最高位必须为 0 (RFC 6455 5.2)
+var errInvalidFrameLength = errors.New("websocket frame length has the most significant bit set")
+
+const (
+	wsDialTimeout  = 10 * time.Second
+	wsCloseTimeout = 3 * time.Second // 主动关闭后等待对端回复关闭帧的时间
+)
+
+// 判断是否为 WebSocket 升级请求
+func IsWebSocketRequest(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// 当前活跃的 WebSocket 连接数
+func (p *ReverseProxy) ActiveWebSockets() int {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+	return len(p.wsSessions)
+}
+
+// 向所有 WebSocket 连接的双方发送 1001 关闭帧，并等待连接结束或 ctx 超时
+// 调用后不再接受新的 WebSocket 连接
+func (p *ReverseProxy) CloseWebSockets(ctx context.Context) error {
+	p.wsMu.Lock()
+	p.wsClosed = true
+	sessions := make([]*wsSession, 0, len(p.wsSessions))
+	for s := range p.wsSessions {
+		sessions = append(sessions, s)
+	}
+	p.wsMu.Unlock()
+
+	for _, s := range sessions {
+		s.close(wsCloseGoingAway, "server shutting down")
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for p.ActiveWebSockets() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// 代理 WebSocket 连接
+func (p *ReverseProxy) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	// 检查并发连接数
+	if !p.acquireWebSocketSlot() {
+		http.Error(w, "too many websocket connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.releaseWebSocketSlot()
+
+	// 按活跃连接数选择目标
+	target := p.wsBalancer.Acquire()
+	if target == "" {
+		http.Error(w, "no available targets", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.wsBalancer.Release(target)
+
+	up := p.upstreams[target]
+
+	outreq := r.Clone(r.Context())
+	up.proxy.Director(outreq)
+
+	backendConn, err := dialUpstream(r.Context(), up)
+	if err != nil {
+		log.Printf("websocket dial %s: %v", target, err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	// 转发握手请求
+	if err := outreq.Write(backendConn); err != nil {
+		log.Printf("websocket handshake %s: %v", target, err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+
+	backendReader := bufio.NewReader(backendConn)
+	resp, err := http.ReadResponse(backendReader, outreq)
+	if err != nil {
+		log.Printf("websocket handshake %s: %v", target, err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// 上游拒绝升级时按普通响应返回
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("websocket hijack: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	// 将上游的 101 响应写回客户端，上游未选择协议时回显网关代为选择的协议
+	if protocol, ok := r.Context().Value(wsProtocolKey{}).(string); ok && resp.Header.Get("Sec-WebSocket-Protocol") == "" {
+		resp.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	clientBuf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		return
+	}
+
+	s := &wsSession{
+		client:   clientConn,
+		clientR:  clientBuf.Reader,
+		backend:  backendConn,
+		backendR: backendReader,
+		idle:     p.wsConfig.IdleTimeout,
+	}
+
+	p.wsMu.Lock()
+	p.wsSessions[s] = struct{}{}
+	p.wsMu.Unlock()
+
+	start := time.Now()
+	s.run()
+
+	p.wsMu.Lock()
+	delete(p.wsSessions, s)
+	p.wsMu.Unlock()
+
+	log.Printf("websocket %s -> %s closed after %s", r.URL.Path, target, time.Since(start).Round(time.Millisecond))
+}
+
+type wsProtocolKey struct{}
+
+// 设置网关在 101 响应中回显的子协议
+// 客户端只通过 Sec-WebSocket-Protocol 传递 token 时，该协议在转发前被移除，上游不会选择协议，
+// 而浏览器在提供了协议却没有收到协议时会使握手失败
+func WithWebSocketProtocol(r *http.Request, protocol string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), wsProtocolKey{}, protocol))
+}
+
+// 占用一个 WebSocket 连接名额
+func (p *ReverseProxy) acquireWebSocketSlot() bool {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+
+	if p.wsClosed {
+		return false
+	}
+	if p.wsConfig.MaxConnections > 0 && p.wsActive >= p.wsConfig.MaxConnections {
+		return false
+	}
+
+	p.wsActive++
+	return true
+}
+
+// 释放 WebSocket 连接名额
+func (p *ReverseProxy) releaseWebSocketSlot() {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+	p.wsActive--
+}
+
+// 与上游建立 TCP 或 TLS 连接
+func dialUpstream(ctx context.Context, up *upstream) (net.Conn, error) {
+	addr := up.url.Host
+	if up.url.Port() == "" {
+		if up.url.Scheme == "https" {
+			addr = net.JoinHostPort(up.url.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(up.url.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: wsDialTimeout}
+	if up.url.Scheme != "https" {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	// WebSocket 只能基于 HTTP/1.1 升级，不协商 HTTP/2
+	tlsConfig := &tls.Config{}
+	if up.tlsConfig != nil {
+		tlsConfig = up.tlsConfig.Clone()
+	}
+	tlsConfig.NextProtos = []string{"http/1.1"}
+
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+	return tlsDialer.DialContext(ctx, "tcp", addr)
+}
+
+// 一条已建立的 WebSocket 连接，按帧在客户端与上游之间转发
+type wsSession struct {
+	client   net.Conn
+	clientR  *bufio.Reader
+	backend  net.Conn
+	backendR *bufio.Reader
+
+	// 写锁保证帧的完整性，关闭帧只会插入在两个完整的帧之间
+	clientW  sync.Mutex
+	backendW sync.Mutex
+
+	idle      time.Duration
+	idleTimer *time.Timer
+	closing   atomic.Bool
+	closeOnce sync.Once
+}
+
+// 开始双向转发，直到连接结束
+func (s *wsSession) run() {
+	if s.idle > 0 {
+		s.idleTimer = time.AfterFunc(s.idle, func() {
+			s.close(wsCloseGoingAway, "idle timeout")
+		})
+		defer s.idleTimer.Stop()
+	}
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.pipe(s.clientR, s.backend, &s.backendW) }()
+	go func() { errc <- s.pipe(s.backendR, s.client, &s.clientW) }()
+
+	// 正常结束时一个方向断开即关闭双方；主动关闭时等待双方回复关闭帧
+	<-errc
+	if !s.closing.Load() {
+		s.closeConns()
+	}
+	<-errc
+	s.closeConns()
+}
+
+// 从 src 读取帧并写入 dst
+func (s *wsSession) pipe(src *bufio.Reader, dst net.Conn, mu *sync.Mutex) error {
+	for {
+		header, err := readFrameHeader(src)
+		if errors.Is(err, errInvalidFrameLength) {
+			s.close(wsCloseProtocolError, "invalid frame length")
+		}
+		if err != nil {
+			return err
+		}
+		s.touch()
+
+		mu.Lock()
+		// 网关已主动发送关闭帧后，不再转发任何帧
+		if s.closing.Load() {
+			_, err = io.CopyN(io.Discard, src, header.length)
+			mu.Unlock()
+			if err != nil || header.opcode == wsOpClose {
+				return err
+			}
+			continue
+		}
+
+		_, err = dst.Write(header.raw)
+		if err == nil {
+			_, err = io.CopyN(dst, src, header.length)
+		}
+		mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 收到数据帧时重置空闲计时
+func (s *wsSession) touch() {
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(s.idle)
+	}
+}
+
+// 主动向双方发送关闭帧
+func (s *wsSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		s.closing.Store(true)
+		deadline := time.Now().Add(wsCloseTimeout)
+
+		s.clientW.Lock()
+		s.client.SetWriteDeadline(deadline)
+		s.client.Write(closeFrame(code, reason, false))
+		s.clientW.Unlock()
+
+		// 网关对上游而言是客户端，发出的帧必须掩码
+		s.backendW.Lock()
+		s.backend.SetWriteDeadline(deadline)
+		s.backend.Write(closeFrame(code, reason, true))
+		s.backendW.Unlock()
+
+		s.client.SetReadDeadline(deadline)
+		s.backend.SetReadDeadline(deadline)
+	})
+}
+
+// 关闭双方连接
+func (s *wsSession) closeConns() {
+	s.client.Close()
+	s.backend.Close()
+}
+
+// 帧头信息
+type frameHeader struct {
+	raw    []byte // 原始帧头 (含掩码)
+	opcode byte
+	length int64 // 负载长度
+}
+
+// 读取一个帧头
+func readFrameHeader(r *bufio.Reader) (frameHeader, error) {
+	raw := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return frameHeader{}, err
+	}
+
+	header := frameHeader{opcode: raw[0] & 0x0f}
+	masked := raw[1]&0x80 != 0
+
+	extra := 0
+	switch raw[1] & 0x7f {
+	case 126:
+		extra = 2
+	case 127:
+		extra = 8
+	default:
+		header.length = int64(raw[1] & 0x7f)
+	}
+	if masked {
+		extra += 4
+	}
+
+	raw = raw[:2+extra]
+	if _, err := io.ReadFull(r, raw[2:]); err != nil {
+		return frameHeader{}, err
+	}
+
+	switch raw[1] & 0x7f {
+	case 126:
+		header.length = int64(binary.BigEndian.Uint16(raw[2:4]))
+	case 127:
+		length := binary.BigEndian.Uint64(raw[2:10])
+		if length > math.MaxInt64 {
+			return frameHeader{}, errInvalidFrameLength
+		}
+		header.length = int64(length)
+	}
+
+	header.raw = raw
+	return header, nil
+}
+
+// 构造关闭帧，控制帧负载不超过 125 字节
+func closeFrame(code int, reason string, mask bool) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	frame := []byte{0x80 | wsOpClose, byte(len(payload))}
+	if !mask {
+		return append(frame, payload...)
+	}
+
+	key := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		// 掩码只需让中间代理无法预测，随机源不可用时使用当前时间
+		binary.BigEndian.PutUint32(key, uint32(time.Now().UnixNano()))
+	}
+	frame[1] |= 0x80
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+
+	return frame
+}
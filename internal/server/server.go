@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 网关 HTTP 服务，负责监听端的 TLS 与 HTTP/2 配置以及优雅关闭
+type Server struct {
+	cfg        config.ProxyConfig
+	httpServer *http.Server
+
+	healthy  atomic.Bool  // 开始关闭后置为 false，/health 据此返回失败
+	inFlight atomic.Int64 // 进行中的请求数，包括 WebSocket 长连接
+
+	newConns sync.Map     // 已接受但尚未读取到请求的连接
+	pending  atomic.Int64 // newConns 中的连接数
+
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+// 创建网关服务
+func New(cfg config.ProxyConfig, handler http.Handler) *Server {
+	s := &Server{cfg: cfg}
+	s.healthy.Store(true)
+
+	// 统计进行中的请求，需要放在 h2c 之内才能按单个请求计数
+	handler = s.trackInFlight(handler)
+
+	// 启用 h2c 时，明文连接也可以使用 HTTP/2
+	if cfg.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	s.httpServer = &http.Server{
+		Addr:      cfg.Listen,
+		Handler:   handler,
+		ConnState: s.trackConnState,
+	}
+
+	return s
+}
+
+// 监听配置的地址并提供服务
+func (s *Server) ListenAndServe() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// 在指定监听器上提供服务
+func (s *Server) Serve(ln net.Listener) error {
+	// TLS 连接由 net/http 通过 ALPN 自动协商 HTTP/2
+	if s.cfg.TLS != nil {
+		return s.httpServer.ServeTLS(ln, s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+	}
+
+	return s.httpServer.Serve(ln)
+}
+
+// 服务是否健康，开始关闭后返回 false
+func (s *Server) Healthy() bool {
+	return s.healthy.Load()
+}
+
+// 当前进行中的请求数
+func (s *Server) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// 统计进行中请求的中间层
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 记录尚未读取到请求的新连接，热升级时需要等待它们交付首个请求
+func (s *Server) trackConnState(c net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		s.newConns.Store(c, struct{}{})
+		s.pending.Add(1)
+		return
+	}
+	if _, ok := s.newConns.LoadAndDelete(c); ok {
+		s.pending.Add(-1)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+)
+
+// 默认的请求排空超时
+const defaultDrainTimeout = 30 * time.Second
+
+// 热升级后等待旧进程已接受连接交付首个请求的最长时间
+const handoffTimeout = time.Second
+
+// 关闭钩子，用于关闭 WebSocket、停止后台任务等
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// 优雅关闭的结果
+type DrainSummary struct {
+	InFlight int64         // 开始排空时进行中的请求数
+	Drained  int64         // 在超时前完成的请求数
+	Aborted  int64         // 超时后被强制中断的请求数
+	Hooks    []string      // 执行过的关闭钩子
+	Errors   []error       // 关闭钩子或服务关闭返回的错误
+	Duration time.Duration // 整个关闭流程耗时
+}
+
+func (d DrainSummary) String() string {
+	return fmt.Sprintf("drained %d/%d in-flight requests (%d aborted), ran hooks %v, %d errors, took %s",
+		d.Drained, d.InFlight, d.Aborted, d.Hooks, len(d.Errors), d.Duration.Round(time.Millisecond))
+}
+
+// 注册关闭钩子，钩子在停止接受新连接后并发执行，并与请求排空共享超时
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// 启动服务
+// 收到 SIGINT/SIGTERM 后执行优雅关闭；收到 SIGUSR2 时将监听 socket 交给新拉起的进程，
+// 新进程就绪后旧进程停止接受新连接并排空
+func (s *Server) Run(cfg config.ShutdownConfig) error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(ln) }()
+
+	// 由旧进程拉起时通知其已就绪
+	notifyReady()
+
+	if s.cfg.PIDFile != "" {
+		if err := writePIDFile(s.cfg.PIDFile); err != nil {
+			log.Printf("Failed to write pid file: %v", err)
+		}
+		defer removePIDFile(s.cfg.PIDFile)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, upgradeSignals...)...)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case sig := <-sigs:
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				// 恢复默认信号处理，再次收到信号时立即退出
+				signal.Stop(sigs)
+				log.Println("Shutdown signal received, draining connections...")
+
+				summary := s.GracefulShutdown(cfg)
+				log.Printf("Shutdown complete: %s", summary)
+				return errors.Join(summary.Errors...)
+			}
+
+			log.Println("Upgrade signal received, starting new process...")
+			if err := s.upgrade(ln); err != nil {
+				log.Printf("Upgrade failed, continuing to serve: %v", err)
+				continue
+			}
+
+			// 新进程已在同一 socket 上接受连接，旧进程无需摘除健康检查
+			signal.Stop(sigs)
+			log.Println("New process is ready, draining old process...")
+			s.handoff(ln)
+
+			summary := s.drain(cfg)
+			log.Printf("Upgrade complete: %s", summary)
+			return errors.Join(summary.Errors...)
+		}
+	}
+}
+
+// 优雅关闭：
+// 1. /health 返回失败，等待 preStopDelay 让上游负载均衡器摘除实例 (期间仍正常服务)
+// 2. 停止接受新连接，等待进行中的请求完成，同时执行关闭钩子
+// 3. 超过 drainTimeout 后强制关闭剩余连接
+func (s *Server) GracefulShutdown(cfg config.ShutdownConfig) DrainSummary {
+	start := time.Now()
+	s.healthy.Store(false)
+
+	if cfg.PreStopDelay > 0 {
+		time.Sleep(cfg.PreStopDelay)
+	}
+
+	summary := s.drain(cfg)
+	summary.Duration = time.Since(start)
+	return summary
+}
+
+// 停止接受新连接并排空进行中的请求
+func (s *Server) drain(cfg config.ShutdownConfig) DrainSummary {
+	start := time.Now()
+
+	drainTimeout := cfg.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	summary := DrainSummary{InFlight: s.inFlight.Load()}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	addErr := func(err error) {
+		mu.Lock()
+		summary.Errors = append(summary.Errors, err)
+		mu.Unlock()
+	}
+
+	// 停止接受新连接并等待普通请求完成
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.httpServer.Shutdown(ctx)
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, net.ErrClosed) {
+			addErr(fmt.Errorf("http server: %w", err))
+		}
+	}()
+
+	// 执行关闭钩子 (关闭 WebSocket、停止后台任务等)
+	s.mu.Lock()
+	hooks := append([]shutdownHook(nil), s.hooks...)
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		summary.Hooks = append(summary.Hooks, hook.name)
+
+		wg.Add(1)
+		go func(hook shutdownHook) {
+			defer wg.Done()
+			if err := hook.fn(ctx); err != nil {
+				addErr(fmt.Errorf("%s: %w", hook.name, err))
+			}
+		}(hook)
+	}
+	wg.Wait()
+
+	// http.Server.Shutdown 不会等待被劫持的连接 (WebSocket)，这里统一等待所有处理函数返回
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.inFlight.Load() > 0 && ctx.Err() == nil {
+		<-ticker.C
+	}
+
+	// 超时后强制关闭剩余连接
+	if remaining := s.inFlight.Load(); remaining > 0 {
+		summary.Aborted = remaining
+		if err := s.httpServer.Close(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			addErr(fmt.Errorf("http server: %w", err))
+		}
+	}
+
+	summary.Drained = summary.InFlight - summary.Aborted
+	if summary.Drained < 0 {
+		summary.Drained = 0
+	}
+	summary.Duration = time.Since(start)
+
+	return summary
+}
+
+// 停止在共享 socket 上接受连接，并等待已接受的连接交付首个请求
+// http.Server.Shutdown 会直接关闭尚未读取到请求的连接，不等待会导致这部分请求失败
+func (s *Server) handoff(ln net.Listener) {
+	ln.Close()
+
+	deadline := time.Now().Add(handoffTimeout)
+	for s.pending.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 写入进程号文件
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// 删除进程号文件，热升级后文件已属于新进程时保留
+func removePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err == nil && string(data) == strconv.Itoa(os.Getpid()) {
+		os.Remove(path)
+	}
+}
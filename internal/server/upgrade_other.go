@@ -0,0 +1,22 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// 非 Unix 平台不支持热升级
+var upgradeSignals []os.Signal
+
+func (s *Server) listen() (net.Listener, error) {
+	return net.Listen("tcp", s.cfg.Listen)
+}
+
+func notifyReady() {}
+
+func (s *Server) upgrade(ln net.Listener) error {
+	return errors.New("binary upgrade is not supported on this platform")
+}
@@ -0,0 +1,131 @@
+//go:build unix
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 热升级时传递给新进程的环境变量
+const (
+	envListenFD = "GOGATE_LISTEN_FD" // 继承的监听 socket
+	envReadyFD  = "GOGATE_READY_FD"  // 新进程就绪后写入的管道
+)
+
+// 等待新进程就绪的最长时间
+const upgradeReadyTimeout = 10 * time.Second
+
+// 触发热升级的信号
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// 创建监听器，由旧进程拉起时继承其监听 socket
+func (s *Server) listen() (net.Listener, error) {
+	fdStr := os.Getenv(envListenFD)
+	if fdStr == "" {
+		return net.Listen("tcp", s.cfg.Listen)
+	}
+	os.Unsetenv(envListenFD)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envListenFD, err)
+	}
+
+	f := os.NewFile(uintptr(fd), "inherited-listener")
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// 通知父进程已开始接受连接
+func notifyReady() {
+	fdStr := os.Getenv(envReadyFD)
+	if fdStr == "" {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "ready-pipe")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// 以相同参数启动新的二进制并传递监听 socket，新进程就绪后返回
+func (s *Server) upgrade(ln net.Listener) error {
+	fileListener, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return errors.New("listener does not support file descriptors")
+	}
+
+	lnFile, err := fileListener.File()
+	if err != nil {
+		return fmt.Errorf("dup listener: %w", err)
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+
+	// ExtraFiles 中的文件在子进程中从 fd 3 开始编号
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(os.Environ(), envListenFD+"=3", envReadyFD+"=4")
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("start new process: %w", err)
+	}
+
+	// 子进程退出或超时都视为升级失败，旧进程继续服务
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			ready <- fmt.Errorf("new process exited before becoming ready: %w", err)
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+	case <-time.After(upgradeReadyTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("timed out waiting for new process")
+	}
+
+	// 新进程独立运行，不再等待其退出
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试 API Key 认证与按路由选择认证方式
+func TestAPIKeyAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{SecretKey: "apikey-test-secret"})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+	token, _ := jwtMiddleware.GenerateToken("u-1", "alice")
+
+	// 部分 API Key 从文件加载
+	keyFile := filepath.Join(t.TempDir(), "apikeys.yaml")
+	content := "- hash: " + auth.HashAPIKey("reports-key") + "\n  consumer: reports\n  routes: [\"/api/reports\"]\n"
+	if err := os.WriteFile(keyFile, []byte(content), 0600); err != nil {
+		t.Fatalf("写入 API Key 文件失败: %v", err)
+	}
+
+	authenticator, err := middleware.NewAuthenticator(config.AuthConfig{
+		APIKeys: config.APIKeysConfig{
+			QueryParam: "api_key",
+			File:       keyFile,
+			Keys: []config.APIKeyConfig{
+				{Hash: auth.HashAPIKey("billing-key"), Consumer: "billing", Tier: "gold"},
+			},
+		},
+		Routes: map[string][]string{
+			"/api/partners": {"apiKey"},
+			"/api/reports":  {"jwt", "apiKey"},
+			"/api/public":   {"none"},
+			"/api/open":     {},
+		},
+	}, jwtMiddleware)
+	if err != nil {
+		t.Fatalf("创建认证中间件失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(authenticator.Handle())
+	r.Any("/api/*path", func(c *gin.Context) {
+		body := gin.H{
+			"userId": c.GetString("userId"),
+			"apiKey": c.GetHeader("X-API-Key"),
+			"query":  c.Request.URL.RawQuery,
+		}
+		if consumer, ok := c.Get("consumer"); ok {
+			body["consumer"] = consumer.(*auth.Consumer).Name
+			body["tier"] = consumer.(*auth.Consumer).Tier
+		}
+		c.JSON(200, body)
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		apiKey   string
+		jwt      string
+		status   int
+		code     string
+		consumer string
+		userId   string
+	}{
+		{"请求头携带 API Key", "/api/partners/orders", "billing-key", "", 200, "", "billing", ""},
+		{"查询参数携带 API Key", "/api/partners/orders?api_key=billing-key&page=2", "", "", 200, "", "billing", ""},
+		{"缺少 API Key", "/api/partners/orders", "", token, 401, "api_key_missing", "", ""},
+		{"无效的 API Key", "/api/partners/orders", "wrong-key", "", 401, "invalid_api_key", "", ""},
+		{"API Key 不允许访问该路由", "/api/partners/orders", "reports-key", "", 403, "route_not_allowed", "", ""},
+		{"两种方式均可：API Key", "/api/reports/daily", "reports-key", "", 200, "", "reports", ""},
+		{"两种方式均可：JWT", "/api/reports/daily", "", token, 200, "", "", "u-1"},
+		{"两种方式均可：都未携带", "/api/reports/daily", "", "", 401, "token_missing", "", ""},
+		{"无需认证", "/api/public/info", "", "", 200, "", "", ""},
+		{"空列表无需认证", "/api/open/info", "", "", 200, "", "", ""},
+		{"未配置的路由只接受 JWT", "/api/users", "billing-key", "", 401, "token_missing", "", ""},
+		{"未配置的路由使用 JWT", "/api/users", "", token, 200, "", "", "u-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			if tt.jwt != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.jwt)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("期望状态码 %d，获得 %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			var body map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if tt.code != "" && body["code"] != tt.code {
+				t.Errorf("期望错误码 %s，获得 %v", tt.code, body["code"])
+			}
+			if w.Code != 200 {
+				return
+			}
+			if tt.consumer != "" && body["consumer"] != tt.consumer {
+				t.Errorf("期望调用方 %s，获得 %v", tt.consumer, body["consumer"])
+			}
+			if body["userId"] != tt.userId {
+				t.Errorf("期望 userId %q，获得 %v", tt.userId, body["userId"])
+			}
+		})
+	}
+
+	t.Run("KeyNotForwarded", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/partners/orders?api_key=billing-key&page=2", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body["query"] != "page=2" {
+			t.Errorf("查询参数中的 API Key 应被移除，获得 %v", body["query"])
+		}
+		if body["tier"] != "gold" {
+			t.Errorf("期望限流等级 gold，获得 %v", body["tier"])
+		}
+
+		req = httptest.NewRequest("GET", "/api/partners/orders", nil)
+		req.Header.Set("X-API-Key", "billing-key")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body["apiKey"] != "" {
+			t.Errorf("请求头中的 API Key 应被移除，获得 %v", body["apiKey"])
+		}
+	})
+
+	t.Run("DefaultRoutes", func(t *testing.T) {
+		// 未配置 auth.routes 时只有 /api 下的路由需要 JWT，/api/auth 与其他路由无需认证
+		authenticator, err := middleware.NewAuthenticator(config.AuthConfig{}, jwtMiddleware)
+		if err != nil {
+			t.Fatalf("创建认证中间件失败: %v", err)
+		}
+		r := gin.New()
+		r.Use(authenticator.Handle())
+		r.Any("/*path", func(c *gin.Context) { c.Status(200) })
+
+		for path, want := range map[string]int{
+			"/api/users":       401,
+			"/api/auth/status": 200,
+			"/dashboard":       200,
+		} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != want {
+				t.Errorf("%s: 期望状态码 %d，获得 %d", path, want, w.Code)
+			}
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		configs := map[string]config.AuthConfig{
+			"无效的哈希": {APIKeys: config.APIKeysConfig{Keys: []config.APIKeyConfig{{Hash: "billing-key", Consumer: "billing"}}}},
+			"缺少调用方": {APIKeys: config.APIKeysConfig{Keys: []config.APIKeyConfig{{Hash: auth.HashAPIKey("k")}}}},
+			"重复的哈希": {APIKeys: config.APIKeysConfig{Keys: []config.APIKeyConfig{
+				{Hash: auth.HashAPIKey("k"), Consumer: "a"},
+				{Hash: auth.HashAPIKey("k"), Consumer: "b"},
+			}}},
+			"未知的认证方式":      {Routes: map[string][]string{"/api": {"basic"}}},
+			"none 与其他方式组合": {Routes: map[string][]string{"/api": {"none", "jwt"}}},
+		}
+		for name, cfg := range configs {
+			if _, err := middleware.NewAuthenticator(cfg, jwtMiddleware); err == nil {
+				t.Errorf("%s: 期望创建失败", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试路由级授权：scope、角色、声明与 HTTP 方法规则，拒绝时返回 403 与缺少的权限
+func TestAuthorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const secret = "authz-test-secret"
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		SecretKey: secret,
+		Exclude:   []string{"/api/public"},
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+
+	authorizer := middleware.NewAuthorizer(config.AuthzConfig{
+		Routes: map[string][]config.AuthzRuleConfig{
+			"/api/admin": {{Roles: []string{"admin", "superuser"}}},
+			"/api/orders": {
+				{Methods: []string{"get"}, Scopes: []string{"orders:read"}},
+				{Methods: []string{"POST", "DELETE"}, Scopes: []string{"orders:read", "orders:write"}},
+			},
+			"/api/orders/export":  {{Claims: map[string][]string{"org.plan": {"enterprise"}}}},
+			"/api/public/reports": {{Scopes: []string{"reports"}}},
+		},
+	})
+
+	r := gin.New()
+	r.Use(jwtMiddleware.Handle(), authorizer.Handle())
+	r.Any("/api/*path", func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("签发 token 失败: %v", err)
+		}
+		return token
+	}
+
+	admin := sign(jwt.MapClaims{"userId": "1", "roles": []string{"admin"}})
+	reader := sign(jwt.MapClaims{"userId": "2", "scope": "profile orders:read"})
+	writer := sign(jwt.MapClaims{"userId": "3", "scope": []string{"orders:read", "orders:write"}, "org": map[string]interface{}{"plan": "enterprise"}})
+	nobody := sign(jwt.MapClaims{"userId": "4"})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+		code   string
+		error  string
+	}{
+		{"无规则的路由", "GET", "/api/users", nobody, 200, "", ""},
+		{"具备角色", "GET", "/api/admin/users", admin, 200, "", ""},
+		{"缺少角色", "GET", "/api/admin/users", reader, 403, "missing_role", "missing role admin or superuser"},
+		{"读取需要读 scope", "GET", "/api/orders", reader, 200, "", ""},
+		{"缺少读 scope", "GET", "/api/orders", nobody, 403, "insufficient_scope", "missing scope orders:read"},
+		{"写入需要写 scope", "POST", "/api/orders", reader, 403, "insufficient_scope", "missing scope orders:write"},
+		{"具备写 scope", "DELETE", "/api/orders/1", writer, 200, "", ""},
+		{"未列出的方法", "PUT", "/api/orders/1", nobody, 200, "", ""},
+		{"声明匹配", "GET", "/api/orders/export", writer, 200, "", ""},
+		{"声明不匹配", "GET", "/api/orders/export", reader, 403, "claim_not_allowed", "claim org.plan must be one of [enterprise]"},
+		{"无需认证的路由", "GET", "/api/public/info", "", 200, "", ""},
+		{"无需认证但有授权规则", "GET", "/api/public/reports", "", 403, "insufficient_scope", "missing scope reports"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("期望状态码 %d，获得 %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.code == "" {
+				return
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("解析响应失败: %v", err)
+			}
+			if body["code"] != tt.code || body["error"] != tt.error {
+				t.Errorf("期望 %s (%s)，获得 %s (%s)", tt.code, tt.error, body["code"], body["error"])
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/handler"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试声明转发：按配置将声明 (包括嵌套声明) 写入上游请求头，并移除客户端伪造的同名请求头
+func TestClaimHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 上游返回收到的身份头
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]string{
+			"X-User-Id": r.Header.Values("X-User-Id"),
+			"X-Tenant":  r.Header.Values("X-Tenant"),
+			"X-Roles":   r.Header.Values("X-Roles"),
+			"X-Level":   r.Header.Values("X-Level"),
+		})
+	}))
+	defer upstream.Close()
+
+	const secret = "claim-headers-secret"
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		SecretKey: secret,
+		Exclude:   []string{"/api/public"},
+		ClaimHeaders: map[string]string{
+			"x-user-id": "userId",
+			"X-Tenant":  "org.tenant",
+			"X-Roles":   "org.roles",
+			"X-Level":   "org.level",
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+
+	proxyHandler, err := handler.NewProxyHandler(map[string]config.RouteConfig{
+		"/api": {Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}}},
+	})
+	if err != nil {
+		t.Fatalf("创建代理处理器失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		jwtMiddleware.Handle()(c)
+		if !c.IsAborted() {
+			proxyHandler.Handle(c)
+		}
+		c.Abort()
+	})
+	gateway := httptest.NewServer(r)
+	defer gateway.Close()
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("签发 token 失败: %v", err)
+		}
+		return token
+	}
+
+	call := func(path, token string) map[string][]string {
+		t.Helper()
+
+		req, _ := http.NewRequest("GET", gateway.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		// 客户端伪造的身份头
+		req.Header.Set("X-User-Id", "admin")
+		req.Header.Add("X-Tenant", "spoofed")
+		req.Header.Set("X-Level", "99")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 200 {
+			t.Fatalf("期望状态码 200，获得 %d", resp.StatusCode)
+		}
+
+		var headers map[string][]string
+		if err := json.NewDecoder(resp.Body).Decode(&headers); err != nil {
+			t.Fatalf("解析响应失败: %v", err)
+		}
+		return headers
+	}
+
+	t.Run("ForwardClaims", func(t *testing.T) {
+		headers := call("/api/users", sign(jwt.MapClaims{
+			"userId": "42",
+			"org": map[string]interface{}{
+				"tenant": "acme",
+				"roles":  []string{"reader", "writer"},
+				"level":  3,
+			},
+		}))
+
+		expected := map[string]string{"X-User-Id": "42", "X-Tenant": "acme", "X-Roles": "reader,writer", "X-Level": "3"}
+		for name, value := range expected {
+			if got := headers[name]; len(got) != 1 || got[0] != value {
+				t.Errorf("%s 期望 %q，获得 %q", name, value, got)
+			}
+		}
+	})
+
+	t.Run("MissingClaims", func(t *testing.T) {
+		// 缺失的声明不能让伪造的请求头透传
+		headers := call("/api/users", sign(jwt.MapClaims{"userId": "42"}))
+
+		if got := headers["X-User-Id"]; len(got) != 1 || got[0] != "42" {
+			t.Errorf("X-User-Id 期望 42，获得 %q", got)
+		}
+		for _, name := range []string{"X-Tenant", "X-Roles", "X-Level"} {
+			if got := headers[name]; len(got) != 0 {
+				t.Errorf("%s 应被移除，获得 %q", name, got)
+			}
+		}
+	})
+
+	t.Run("ExcludedPath", func(t *testing.T) {
+		// 无需认证的路径同样移除伪造的身份头
+		headers := call("/api/public/info", "")
+
+		for _, name := range []string{"X-User-Id", "X-Tenant", "X-Level"} {
+			if got := headers[name]; len(got) != 0 {
+				t.Errorf("%s 应被移除，获得 %q", name, got)
+			}
+		}
+	})
+}
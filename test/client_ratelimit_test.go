@@ -0,0 +1,163 @@
+package test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试按客户端 IP、用户、API Key 与请求头分别限流
+func TestClientRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(t *testing.T, cfg config.RateLimitConfig, trustedProxies ...string) (*gin.Engine, *middleware.RateLimiter) {
+		t.Helper()
+		rateLimiter, err := middleware.NewRateLimiter(cfg)
+		if err != nil {
+			t.Fatalf("创建限流中间件失败: %v", err)
+		}
+		r := gin.New()
+		// 与网关一致，默认不信任任何代理
+		if err := r.SetTrustedProxies(trustedProxies); err != nil {
+			t.Fatalf("设置可信代理失败: %v", err)
+		}
+		// 模拟认证中间件设置的身份
+		r.Use(func(c *gin.Context) {
+			if userID := c.GetHeader("X-Test-User"); userID != "" {
+				c.Set("userId", userID)
+			}
+			// 身份提供方签发的 token 只有 sub
+			if subject := c.GetHeader("X-Test-Subject"); subject != "" {
+				c.Set("claims", &middleware.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}})
+			}
+			if name := c.GetHeader("X-Test-Consumer"); name != "" {
+				c.Set("consumer", &auth.Consumer{Name: name})
+			}
+		}, rateLimiter.Handle())
+		r.Any("/*path", func(c *gin.Context) { c.Status(200) })
+		return r, rateLimiter
+	}
+
+	doFrom := func(r *gin.Engine, remoteAddr, path string, headers map[string]string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	do := func(r *gin.Engine, path string, headers map[string]string) int {
+		return doFrom(r, "10.0.0.1:1234", path, headers)
+	}
+
+	expect := func(t *testing.T, r *gin.Engine, path string, headers map[string]string, want int) {
+		t.Helper()
+		if code := do(r, path, headers); code != want {
+			t.Fatalf("%s %v: 期望状态码 %d, 实际 %d", path, headers, want, code)
+		}
+	}
+
+	t.Run("SharedWithoutKey", func(t *testing.T) {
+		r, rl := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1})
+		expect(t, r, "/", map[string]string{"X-Test-User": "a"}, 200)
+		expect(t, r, "/", map[string]string{"X-Test-User": "b"}, 429)
+		if rl.TrackedKeys() != 0 {
+			t.Fatalf("共享限额不应跟踪客户端, 实际 %d", rl.TrackedKeys())
+		}
+	})
+
+	t.Run("IP", func(t *testing.T) {
+		r, _ := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1, Key: "ip"})
+		expect(t, r, "/", nil, 200)
+		expect(t, r, "/", nil, 429)
+		// 不可信的客户端伪造 X-Forwarded-For 不会得到新的限额
+		expect(t, r, "/", map[string]string{"X-Forwarded-For": "192.0.2.7"}, 429)
+		if code := doFrom(r, "10.0.0.2:1234", "/", nil); code != 200 {
+			t.Fatalf("其他 IP 期望状态码 200, 实际 %d", code)
+		}
+	})
+
+	t.Run("TrustedProxy", func(t *testing.T) {
+		r, _ := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1, Key: "ip"}, "10.0.0.0/8")
+		// 可信代理转发的请求按 X-Forwarded-For 中的客户端计数
+		expect(t, r, "/", map[string]string{"X-Forwarded-For": "192.0.2.7"}, 200)
+		expect(t, r, "/", map[string]string{"X-Forwarded-For": "192.0.2.7"}, 429)
+		expect(t, r, "/", map[string]string{"X-Forwarded-For": "192.0.2.8"}, 200)
+	})
+
+	t.Run("User", func(t *testing.T) {
+		r, _ := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1, Key: "user"})
+		expect(t, r, "/", map[string]string{"X-Test-User": "alice"}, 200)
+		expect(t, r, "/", map[string]string{"X-Test-User": "alice"}, 429)
+		expect(t, r, "/", map[string]string{"X-Test-User": "bob"}, 200)
+		// 只有 sub 的 token 按 subject 计数，而不是回退到 IP
+		expect(t, r, "/", map[string]string{"X-Test-Subject": "carol"}, 200)
+		expect(t, r, "/", map[string]string{"X-Test-Subject": "carol"}, 429)
+		expect(t, r, "/", map[string]string{"X-Test-Subject": "dave"}, 200)
+		// 未认证的请求按 IP 计数
+		expect(t, r, "/", nil, 200)
+		expect(t, r, "/", nil, 429)
+	})
+
+	t.Run("APIKey", func(t *testing.T) {
+		r, _ := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1, Key: "apiKey"})
+		expect(t, r, "/", map[string]string{"X-Test-Consumer": "billing"}, 200)
+		expect(t, r, "/", map[string]string{"X-Test-Consumer": "billing"}, 429)
+		expect(t, r, "/", map[string]string{"X-Test-Consumer": "reports"}, 200)
+	})
+
+	t.Run("Header", func(t *testing.T) {
+		r, _ := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1, Key: "header:X-Tenant"})
+		expect(t, r, "/", map[string]string{"X-Tenant": "acme"}, 200)
+		expect(t, r, "/", map[string]string{"X-Tenant": "acme"}, 429)
+		expect(t, r, "/", map[string]string{"X-Tenant": "globex"}, 200)
+	})
+
+	t.Run("RouteKeyOverride", func(t *testing.T) {
+		r, _ := newRouter(t, config.RateLimitConfig{
+			Enable: true, Rate: 1000, Burst: 1000, Key: "ip",
+			Routes: map[string]config.RateLimitRouteConfig{
+				"/api/users":  {Rate: 1, Burst: 1, Key: "user"},
+				"/api/orders": {Rate: 1, Burst: 1},
+			},
+		})
+		expect(t, r, "/api/users", map[string]string{"X-Test-User": "alice"}, 200)
+		expect(t, r, "/api/users", map[string]string{"X-Test-User": "alice"}, 429)
+		expect(t, r, "/api/users", map[string]string{"X-Test-User": "bob"}, 200)
+		// 未指定 key 的路由沿用全局的 ip
+		expect(t, r, "/api/orders", map[string]string{"X-Test-User": "alice"}, 200)
+		expect(t, r, "/api/orders", map[string]string{"X-Test-User": "bob"}, 429)
+	})
+
+	t.Run("BoundedKeys", func(t *testing.T) {
+		r, rl := newRouter(t, config.RateLimitConfig{Enable: true, Rate: 1, Burst: 1, Key: "user", MaxKeys: 3})
+		for i := 0; i < 10; i++ {
+			expect(t, r, "/", map[string]string{"X-Test-User": fmt.Sprintf("u-%d", i)}, 200)
+		}
+		if rl.TrackedKeys() != 3 {
+			t.Fatalf("期望跟踪 3 个客户端, 实际 %d", rl.TrackedKeys())
+		}
+		// 最近使用的客户端仍保留限额
+		expect(t, r, "/", map[string]string{"X-Test-User": "u-9"}, 429)
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		for _, cfg := range []config.RateLimitConfig{
+			{Key: "cookie"},
+			{Key: "header:"},
+			{Routes: map[string]config.RateLimitRouteConfig{"/api": {Key: "session"}}},
+		} {
+			if _, err := middleware.NewRateLimiter(cfg); err == nil {
+				t.Fatalf("配置 %+v 应返回错误", cfg)
+			}
+		}
+	})
+}
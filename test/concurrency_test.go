@@ -0,0 +1,327 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/proxy"
+)
+
+// 测试自适应并发限制：达到上限时排队或返回 503，上游变慢时降低上限，恢复后提高上限
+func TestConcurrencyLimit(t *testing.T) {
+	// 模拟上游：gate 不为 nil 时阻塞到 gate 关闭，否则按 delay 延迟响应
+	type upstreamState struct {
+		inflight atomic.Int32
+		delay    atomic.Int64
+		status   atomic.Int32
+		gate     chan struct{}
+	}
+	newUpstream := func(state *upstreamState) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state.inflight.Add(1)
+			defer state.inflight.Add(-1)
+			if state.gate != nil {
+				<-state.gate
+			}
+			time.Sleep(time.Duration(state.delay.Load()))
+			if status := state.status.Load(); status != 0 {
+				w.WriteHeader(int(status))
+				return
+			}
+			io.WriteString(w, "ok")
+		}))
+	}
+	newLimitedProxy := func(t *testing.T, upstream *httptest.Server, cfg config.ConcurrencyConfig) (*proxy.ReverseProxy, *httptest.Server) {
+		t.Helper()
+		cfg.Enable = true
+		p, err := proxy.NewReverseProxy(config.RouteConfig{
+			Targets:     []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			Concurrency: cfg,
+		})
+		if err != nil {
+			t.Fatalf("创建代理失败: %v", err)
+		}
+		gateway := httptest.NewServer(p)
+		t.Cleanup(gateway.Close)
+		return p, gateway
+	}
+	get := func(t *testing.T, url string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Errorf("请求失败: %v", err)
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+	// 等待上游的并发请求数达到 n
+	waitInflight := func(t *testing.T, state *upstreamState, n int32) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for state.inflight.Load() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待上游并发数达到 %d 超时, 实际 %d", n, state.inflight.Load())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	// 并发发送请求，返回各请求的状态码
+	burst := func(t *testing.T, url string, n int) []int {
+		var wg sync.WaitGroup
+		codes := make([]int, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i], _ = get(t, url)
+			}(i)
+		}
+		wg.Wait()
+		return codes
+	}
+
+	t.Run("ShedWhenFull", func(t *testing.T) {
+		state := &upstreamState{gate: make(chan struct{})}
+		upstream := newUpstream(state)
+		defer upstream.Close()
+		_, gateway := newLimitedProxy(t, upstream, config.ConcurrencyConfig{InitialLimit: 2, MaxLimit: 2, QueueSize: -1})
+
+		done := make(chan []int)
+		go func() { done <- burst(t, gateway.URL+"/api/items", 2) }()
+		waitInflight(t, state, 2)
+
+		// 没有排队的名额，立即返回 503
+		if code, body := get(t, gateway.URL+"/api/items"); code != 503 || body != "upstream overloaded" {
+			t.Fatalf("期望 503 upstream overloaded, 实际 %d %q", code, body)
+		}
+
+		close(state.gate)
+		for _, code := range <-done {
+			if code != 200 {
+				t.Fatalf("上限内的请求期望状态码 200, 实际 %d", code)
+			}
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		state := &upstreamState{gate: make(chan struct{})}
+		upstream := newUpstream(state)
+		defer upstream.Close()
+		_, gateway := newLimitedProxy(t, upstream, config.ConcurrencyConfig{
+			InitialLimit: 1,
+			MaxLimit:     1,
+			QueueSize:    1,
+			QueueTimeout: 2 * time.Second,
+		})
+
+		first := make(chan []int)
+		go func() { first <- burst(t, gateway.URL+"/api/items", 1) }()
+		waitInflight(t, state, 1)
+		queued := make(chan []int)
+		go func() { queued <- burst(t, gateway.URL+"/api/items", 1) }()
+		time.Sleep(100 * time.Millisecond)
+
+		// 队列已满
+		if code, _ := get(t, gateway.URL+"/api/items"); code != 503 {
+			t.Fatalf("队列已满期望状态码 503, 实际 %d", code)
+		}
+
+		// 第一个请求完成后排队的请求获得名额
+		close(state.gate)
+		if codes := append(<-first, <-queued...); codes[0] != 200 || codes[1] != 200 {
+			t.Fatalf("期望排队的请求完成, 实际 %v", codes)
+		}
+	})
+
+	t.Run("DefaultQueueSize", func(t *testing.T) {
+		state := &upstreamState{gate: make(chan struct{})}
+		upstream := newUpstream(state)
+		defer upstream.Close()
+		_, gateway := newLimitedProxy(t, upstream, config.ConcurrencyConfig{
+			InitialLimit: 2,
+			MaxLimit:     2,
+			QueueTimeout: 2 * time.Second,
+		})
+
+		first := make(chan []int)
+		go func() { first <- burst(t, gateway.URL+"/api/items", 2) }()
+		waitInflight(t, state, 2)
+		queued := make(chan []int)
+		go func() { queued <- burst(t, gateway.URL+"/api/items", 2) }()
+		time.Sleep(100 * time.Millisecond)
+
+		// 默认最多排队的请求数等于当前上限
+		start := time.Now()
+		if code, _ := get(t, gateway.URL+"/api/items"); code != 503 {
+			t.Fatalf("队列已满期望状态码 503, 实际 %d", code)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("队列已满期望立即返回, 实际 %s", elapsed)
+		}
+
+		close(state.gate)
+		for _, code := range append(<-first, <-queued...) {
+			if code != 200 {
+				t.Fatalf("期望排队的请求完成, 实际 %d", code)
+			}
+		}
+	})
+
+	t.Run("QueueTimeout", func(t *testing.T) {
+		state := &upstreamState{gate: make(chan struct{})}
+		upstream := newUpstream(state)
+		defer upstream.Close()
+		_, gateway := newLimitedProxy(t, upstream, config.ConcurrencyConfig{
+			InitialLimit: 1,
+			MaxLimit:     1,
+			QueueSize:    1,
+			QueueTimeout: 50 * time.Millisecond,
+		})
+
+		done := make(chan []int)
+		go func() { done <- burst(t, gateway.URL+"/api/items", 1) }()
+		waitInflight(t, state, 1)
+
+		start := time.Now()
+		if code, _ := get(t, gateway.URL+"/api/items"); code != 503 {
+			t.Fatalf("排队超时期望状态码 503, 实际 %d", code)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("期望排队 50ms 后返回, 实际 %s", elapsed)
+		}
+		close(state.gate)
+		<-done
+	})
+
+	t.Run("Adaptive", func(t *testing.T) {
+		state := &upstreamState{}
+		upstream := newUpstream(state)
+		defer upstream.Close()
+		p, gateway := newLimitedProxy(t, upstream, config.ConcurrencyConfig{
+			InitialLimit:     10,
+			MinLimit:         2,
+			MaxLimit:         20,
+			LatencyThreshold: 50 * time.Millisecond,
+			BackoffRatio:     0.5,
+		})
+
+		// 上游变慢时按比例降低上限：10 -> 5 -> 2，不低于 minLimit
+		state.delay.Store(int64(80 * time.Millisecond))
+		for i := 0; i < 3; i++ {
+			get(t, gateway.URL+"/api/items")
+		}
+		if limit := p.ConcurrencyLimit(); limit != 2 {
+			t.Fatalf("上游变慢后期望上限降至 2, 实际 %d", limit)
+		}
+
+		// 上游恢复后，并发达到上限的请求逐步提高上限
+		state.delay.Store(int64(10 * time.Millisecond))
+		for i := 0; i < 5; i++ {
+			burst(t, gateway.URL+"/api/items", p.ConcurrencyLimit())
+		}
+		recovered := p.ConcurrencyLimit()
+		if recovered <= 2 {
+			t.Fatalf("上游恢复后期望上限提高, 实际 %d", recovered)
+		}
+
+		// 上游返回 503 同样视为过载
+		state.delay.Store(0)
+		state.status.Store(503)
+		get(t, gateway.URL+"/api/items")
+		if limit := p.ConcurrencyLimit(); limit >= recovered {
+			t.Fatalf("上游返回 503 后期望上限降低, 实际 %d", limit)
+		}
+	})
+
+	t.Run("WithTimeouts", func(t *testing.T) {
+		state := &upstreamState{}
+		upstream := newUpstream(state)
+		defer upstream.Close()
+
+		// 路由的超时不影响上限的调整：5 个串行的请求使上限从 1 提高到 3
+		for name, route := range map[string]config.RouteConfig{
+			"Timeout":     {Timeout: time.Second},
+			"IdleTimeout": {IdleTimeout: time.Second},
+		} {
+			route.Targets = []config.TargetConfig{{URL: upstream.URL, Weight: 1}}
+			route.Concurrency = config.ConcurrencyConfig{Enable: true, InitialLimit: 1}
+			p, err := proxy.NewReverseProxy(route)
+			if err != nil {
+				t.Fatalf("创建代理失败: %v", err)
+			}
+			gateway := httptest.NewServer(p)
+			for i := 0; i < 5; i++ {
+				if code, _ := get(t, gateway.URL+"/api/items"); code != 200 {
+					t.Fatalf("%s: 期望状态码 200, 实际 %d", name, code)
+				}
+			}
+			gateway.Close()
+			if limit := p.ConcurrencyLimit(); limit != 3 {
+				t.Fatalf("%s: 期望上限提高到 3, 实际 %d", name, limit)
+			}
+		}
+
+		// 上游发送响应头后停止发送数据，空闲超时计为过载
+		stalled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "first")
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		defer stalled.Close()
+		p, err := proxy.NewReverseProxy(config.RouteConfig{
+			Targets:     []config.TargetConfig{{URL: stalled.URL, Weight: 1}},
+			IdleTimeout: 100 * time.Millisecond,
+			Concurrency: config.ConcurrencyConfig{Enable: true, InitialLimit: 4},
+		})
+		if err != nil {
+			t.Fatalf("创建代理失败: %v", err)
+		}
+		gateway := httptest.NewServer(p)
+		defer gateway.Close()
+		get(t, gateway.URL+"/api/items")
+		if limit := p.ConcurrencyLimit(); limit != 3 {
+			t.Fatalf("空闲超时后期望上限降至 3, 实际 %d", limit)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		p, err := proxy.NewReverseProxy(config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: "http://localhost:1", Weight: 1}},
+		})
+		if err != nil {
+			t.Fatalf("创建代理失败: %v", err)
+		}
+		if limit := p.ConcurrencyLimit(); limit != 0 {
+			t.Fatalf("未启用时期望上限为 0, 实际 %d", limit)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		for _, bad := range []config.ConcurrencyConfig{
+			{Enable: true, InitialLimit: 2, MinLimit: 5},
+			{Enable: true, InitialLimit: 50, MaxLimit: 10},
+			{Enable: true, BackoffRatio: 1.5},
+			{Enable: true, QueueSize: -2},
+		} {
+			_, err := proxy.NewReverseProxy(config.RouteConfig{
+				Targets:     []config.TargetConfig{{URL: "http://localhost:1", Weight: 1}},
+				Concurrency: bad,
+			})
+			if err == nil {
+				t.Fatalf("配置 %+v 应返回错误", bad)
+			}
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试多个网关实例通过 Redis 共享限额，以及 Redis 不可用时改用本地限流
+func TestDistributedRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mr := miniredis.RunT(t)
+
+	newReplica := func(t *testing.T, cfg config.RateLimitConfig) (*gin.Engine, *middleware.RateLimiter) {
+		t.Helper()
+		cfg.Enable = true
+		cfg.Backend = config.RateLimitBackendConfig{
+			Type:          "redis",
+			Redis:         config.RedisConfig{Addr: mr.Addr()},
+			RetryInterval: 200 * time.Millisecond,
+		}
+		rateLimiter, err := middleware.NewRateLimiter(cfg)
+		if err != nil {
+			t.Fatalf("创建限流中间件失败: %v", err)
+		}
+		t.Cleanup(func() { rateLimiter.Close() })
+
+		r := gin.New()
+		r.Use(rateLimiter.Handle())
+		r.Any("/*path", func(c *gin.Context) { c.Status(200) })
+		return r, rateLimiter
+	}
+
+	do := func(r *gin.Engine, path, ip string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("SharedAcrossReplicas", func(t *testing.T) {
+		mr.FlushAll()
+		cfg := config.RateLimitConfig{Rate: 1, Burst: 4}
+		replicas := []*gin.Engine{}
+		for i := 0; i < 3; i++ {
+			r, _ := newReplica(t, cfg)
+			replicas = append(replicas, r)
+		}
+
+		allowed := 0
+		for i := 0; i < 12; i++ {
+			if do(replicas[i%len(replicas)], "/", "10.0.0.1") == 200 {
+				allowed++
+			}
+		}
+		if allowed != 4 {
+			t.Fatalf("3 个实例共享 burst 4, 期望通过 4 个请求, 实际 %d", allowed)
+		}
+	})
+
+	t.Run("PerClientKeys", func(t *testing.T) {
+		mr.FlushAll()
+		cfg := config.RateLimitConfig{
+			Rate: 1000, Burst: 1000, Key: "ip",
+			Routes: map[string]config.RateLimitRouteConfig{"/api/orders": {Rate: 1, Burst: 1}},
+		}
+		a, _ := newReplica(t, cfg)
+		b, _ := newReplica(t, cfg)
+
+		if code := do(a, "/api/orders", "10.0.0.1"); code != 200 {
+			t.Fatalf("期望状态码 200, 实际 %d", code)
+		}
+		if code := do(b, "/api/orders", "10.0.0.1"); code != 429 {
+			t.Fatalf("同一客户端在另一实例上期望状态码 429, 实际 %d", code)
+		}
+		if code := do(b, "/api/orders", "10.0.0.2"); code != 200 {
+			t.Fatalf("其他客户端期望状态码 200, 实际 %d", code)
+		}
+
+		key := "gogate:ratelimit:route:/api/orders:ip:10.0.0.1"
+		if !mr.Exists(key) {
+			t.Fatalf("Redis 中缺少 key %s, 实际 %v", key, mr.Keys())
+		}
+		// 令牌补满后 key 过期
+		if ttl := mr.TTL(key); ttl <= 0 || ttl > 2*time.Second {
+			t.Fatalf("key 的过期时间不正确: %s", ttl)
+		}
+	})
+
+	t.Run("FallbackWhenUnavailable", func(t *testing.T) {
+		mr.FlushAll()
+		r, rl := newReplica(t, config.RateLimitConfig{Rate: 1, Burst: 2})
+
+		if code := do(r, "/", "10.0.0.1"); code != 200 {
+			t.Fatalf("期望状态码 200, 实际 %d", code)
+		}
+
+		// Redis 不可用时仍按本地令牌桶限流
+		mr.SetError("LOADING Redis is loading the dataset in memory")
+		start := time.Now()
+		codes := []int{do(r, "/", "10.0.0.1"), do(r, "/", "10.0.0.1"), do(r, "/", "10.0.0.1")}
+		if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+			t.Fatalf("本地限流期望状态码 [200 200 429], 实际 %v", codes)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("Redis 不可用时请求耗时过长: %s", elapsed)
+		}
+		if rl.TrackedKeys() != 0 {
+			t.Fatalf("共享限额不应跟踪客户端, 实际 %d", rl.TrackedKeys())
+		}
+
+		// 恢复后经过重试间隔重新使用 Redis 的计数
+		mr.SetError("")
+		time.Sleep(250 * time.Millisecond)
+		if code := do(r, "/", "10.0.0.1"); code != 200 {
+			t.Fatalf("Redis 恢复后期望状态码 200, 实际 %d", code)
+		}
+		if code := do(r, "/", "10.0.0.1"); code != 429 {
+			t.Fatalf("Redis 恢复后期望状态码 429, 实际 %d", code)
+		}
+	})
+
+	t.Run("CustomBackend", func(t *testing.T) {
+		r, rl := newReplica(t, config.RateLimitConfig{Rate: 1, Burst: 1})
+		rl.SetBackend(failingRateLimitBackend{})
+		codes := []int{do(r, "/", "10.0.0.1"), do(r, "/", "10.0.0.1")}
+		if codes[0] != 200 || codes[1] != 429 {
+			t.Fatalf("存储出错时期望按本地限流 [200 429], 实际 %v", codes)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		for _, backend := range []config.RateLimitBackendConfig{
+			{Type: "memcached"},
+			{Type: "redis"},
+		} {
+			if _, err := middleware.NewRateLimiter(config.RateLimitConfig{Backend: backend}); err == nil {
+				t.Fatalf("配置 %+v 应返回错误", backend)
+			}
+		}
+	})
+}
+
+// 总是失败的限流存储
+type failingRateLimitBackend struct{}
+
+func (failingRateLimitBackend) Take(ctx context.Context, rule, client string, policy middleware.RateLimitPolicy) (middleware.RateLimitResult, error) {
+	return middleware.RateLimitResult{}, errors.New("backend down")
+}
+
+func (failingRateLimitBackend) Refund(ctx context.Context, rule, client string, policy middleware.RateLimitPolicy, reservation string) error {
+	return errors.New("backend down")
+}
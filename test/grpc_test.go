@@ -0,0 +1,208 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/handler"
+	"github.com/ilukemagic/gogate/internal/middleware"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 测试 gRPC 代理与错误码映射
+func TestGRPCProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 模拟 gRPC 回显服务：每收到一条消息立即返回一条消息
+	upstream := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		for {
+			msg, err := readGRPCFrame(r.Body)
+			if err != nil {
+				break
+			}
+			w.Write(grpcFrame("echo: " + msg))
+			w.(http.Flusher).Flush()
+		}
+
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "")
+	}), &http2.Server{}))
+	defer upstream.Close()
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{SecretKey: "grpc-test-secret"})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+	rateLimiter, err := middleware.NewRateLimiter(config.RateLimitConfig{
+		Enable: true,
+		Rate:   1000,
+		Burst:  1000,
+		Routes: map[string]config.RateLimitRouteConfig{
+			"/echo.Echo/Limited": {Rate: 1, Burst: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建限流中间件失败: %v", err)
+	}
+	proxyHandler, err := handler.NewProxyHandler(map[string]config.RouteConfig{
+		"/echo.Echo/": {
+			Protocol: config.ProtocolGRPC,
+			Targets:  []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+		},
+		"/empty.Empty/": {
+			Protocol: config.ProtocolGRPC,
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建代理处理器失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(rateLimiter.Handle())
+	r.Use(func(c *gin.Context) {
+		jwtMiddleware.Handle()(c)
+		if !c.IsAborted() {
+			proxyHandler.Handle(c)
+		}
+		c.Abort()
+	})
+
+	addr := startServer(t, config.ProxyConfig{H2C: true}, r)
+	client := newH2CClient()
+
+	token, err := jwtMiddleware.GenerateToken("1", "grpc")
+	if err != nil {
+		t.Fatalf("生成令牌失败: %v", err)
+	}
+
+	t.Run("Streaming", func(t *testing.T) {
+		pr, pw := io.Pipe()
+		req := newGRPCRequest(t, "http://"+addr+"/echo.Echo/Chat", pr, token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		defer resp.Body.Close()
+
+		// 每发送一条消息都应在请求结束前收到回显
+		for i := 0; i < 3; i++ {
+			msg := fmt.Sprintf("ping %d", i)
+			if _, err := pw.Write(grpcFrame(msg)); err != nil {
+				t.Fatalf("发送消息失败: %v", err)
+			}
+
+			reply, err := readGRPCFrame(resp.Body)
+			if err != nil {
+				t.Fatalf("读取回显失败: %v", err)
+			}
+			if reply != "echo: "+msg {
+				t.Fatalf("期望回显 %q，获得 %q", "echo: "+msg, reply)
+			}
+		}
+		pw.Close()
+
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Fatalf("读取响应失败: %v", err)
+		}
+		if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+			t.Fatalf("期望 trailer grpc-status 0，获得 %q", status)
+		}
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		assertGRPCStatus(t, client, "http://"+addr+"/echo.Echo/Chat", "", "16")
+	})
+
+	t.Run("ResourceExhausted", func(t *testing.T) {
+		url := "http://" + addr + "/echo.Echo/Limited"
+		assertGRPCStatus(t, client, url, token, "0")
+		assertGRPCStatus(t, client, url, token, "8")
+	})
+
+	t.Run("NoTargets", func(t *testing.T) {
+		assertGRPCStatus(t, client, "http://"+addr+"/empty.Empty/Call", token, "14")
+	})
+
+	t.Run("UnknownRoute", func(t *testing.T) {
+		assertGRPCStatus(t, client, "http://"+addr+"/unknown.Service/Call", token, "12")
+	})
+}
+
+// 发送一元调用并校验 gRPC 状态码 (可能位于响应头或 trailer 中)
+func assertGRPCStatus(t *testing.T, client *http.Client, url, token, want string) {
+	t.Helper()
+
+	req := newGRPCRequest(t, url, bytes.NewReader(grpcFrame("hello")), token)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("gRPC 响应期望 HTTP 200，获得 %d", resp.StatusCode)
+	}
+
+	status := resp.Header.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Trailer.Get("Grpc-Status")
+	}
+	if status != want {
+		t.Fatalf("期望 grpc-status %s，获得 %q (%s)", want, status, resp.Header.Get("Grpc-Message"))
+	}
+}
+
+// 创建 gRPC 请求
+func newGRPCRequest(t *testing.T, url string, body io.Reader, token string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		t.Fatalf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req
+}
+
+// 编码一条 gRPC 消息 (1 字节压缩标记 + 4 字节长度 + 消息体)
+func grpcFrame(msg string) []byte {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg)))
+	copy(frame[5:], msg)
+	return frame
+}
+
+// 读取一条 gRPC 消息
+func readGRPCFrame(r io.Reader) (string, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:5]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", err
+	}
+
+	return string(msg), nil
+}
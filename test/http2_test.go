@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/server"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 测试网关监听端与上游的 HTTP/2 支持
+func TestHTTP2(t *testing.T) {
+	protoHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	t.Run("ListenerTLS", func(t *testing.T) {
+		dir := t.TempDir()
+		caCert, caKey := newTestCA(t)
+		certFile, keyFile := newServerCert(t, dir, caCert, caKey)
+
+		addr := startServer(t, config.ProxyConfig{
+			TLS: &config.ListenerTLSConfig{CertFile: certFile, KeyFile: keyFile},
+		}, protoHandler)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			ForceAttemptHTTP2: true,
+		}}
+
+		if proto := getBody(t, client, "https://"+addr+"/"); proto != "HTTP/2.0" {
+			t.Fatalf("期望协议 HTTP/2.0，获得 %s", proto)
+		}
+	})
+
+	t.Run("ListenerH2C", func(t *testing.T) {
+		addr := startServer(t, config.ProxyConfig{H2C: true}, protoHandler)
+
+		if proto := getBody(t, newH2CClient(), "http://"+addr+"/"); proto != "HTTP/2.0" {
+			t.Fatalf("期望协议 HTTP/2.0，获得 %s", proto)
+		}
+
+		// 未启用 h2c 的客户端仍然可以使用 HTTP/1.1
+		if proto := getBody(t, http.DefaultClient, "http://"+addr+"/"); proto != "HTTP/1.1" {
+			t.Fatalf("期望协议 HTTP/1.1，获得 %s", proto)
+		}
+	})
+
+	t.Run("UpstreamH2C", func(t *testing.T) {
+		upstream := httptest.NewServer(h2c.NewHandler(protoHandler, &http2.Server{}))
+		defer upstream.Close()
+
+		code, body := proxyRequest(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			HTTP2:   true,
+		})
+		if code != http.StatusOK || body != "HTTP/2.0" {
+			t.Fatalf("期望上游收到 HTTP/2.0 请求，获得 %d %s", code, body)
+		}
+
+		// 未启用 http2 时使用 HTTP/1.1 连接上游
+		_, body = proxyRequest(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+		})
+		if body != "HTTP/1.1" {
+			t.Fatalf("期望上游收到 HTTP/1.1 请求，获得 %s", body)
+		}
+	})
+
+	t.Run("UpstreamTLS", func(t *testing.T) {
+		upstream := httptest.NewUnstartedServer(protoHandler)
+		upstream.EnableHTTP2 = true
+		upstream.StartTLS()
+		defer upstream.Close()
+
+		code, body := proxyRequest(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			TLS:     &config.UpstreamTLSConfig{InsecureSkipVerify: true},
+			HTTP2:   true,
+		})
+		if code != http.StatusOK || body != "HTTP/2.0" {
+			t.Fatalf("期望上游收到 HTTP/2.0 请求，获得 %d %s", code, body)
+		}
+	})
+}
+
+// 在随机端口启动网关服务，返回监听地址
+func startServer(t *testing.T, cfg config.ProxyConfig, handler http.Handler) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+
+	srv := server.New(cfg, handler)
+	go srv.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+
+	return ln.Addr().String()
+}
+
+// 创建使用 h2c (prior knowledge) 的客户端
+func newH2CClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+// 发送 GET 请求并返回响应体
+func getBody(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
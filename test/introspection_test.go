@@ -0,0 +1,213 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试不透明 token 的自省认证：结果缓存、与 JWT 共存、上下文中的用户与 scope
+func TestIntrospection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 自省端点的本地替身
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	tokens := map[string]map[string]interface{}{
+		"opaque-reader": {"active": true, "sub": "u-7", "username": "reader", "scope": "orders:read", "client_id": "web"},
+		"opaque-short":  {"active": true, "sub": "u-8", "exp": time.Now().Add(1500 * time.Millisecond).Unix()},
+		"opaque-other":  {"active": true, "sub": "u-9", "aud": "other-api"},
+	}
+	down := false
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if id, secret, ok := r.BasicAuth(); !ok || id != "gateway" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if down {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		token := r.PostFormValue("token")
+		calls[token]++
+		if result, ok := tokens[token]; ok {
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"active": false})
+	}))
+	defer endpoint.Close()
+	callCount := func(token string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls[token]
+	}
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		SecretKey: "introspection-test-secret",
+		Routes:    map[string]config.JWTRouteConfig{"/api/strict": {Audience: []string{"orders-api"}}},
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+	jwtToken, _ := jwtMiddleware.GenerateToken("u-1", "alice")
+
+	authenticator, err := middleware.NewAuthenticator(config.AuthConfig{
+		Introspection: &config.IntrospectionConfig{
+			URL:          endpoint.URL,
+			ClientID:     "gateway",
+			ClientSecret: "s3cret",
+		},
+		Routes: map[string][]string{
+			"/api/orders": {"introspection"},
+			"/api/strict": {"introspection"},
+			"/api/mixed":  {"jwt", "introspection"},
+		},
+	}, jwtMiddleware)
+	if err != nil {
+		t.Fatalf("创建认证中间件失败: %v", err)
+	}
+	authorizer := middleware.NewAuthorizer(config.AuthzConfig{
+		Routes: map[string][]config.AuthzRuleConfig{
+			"/api/orders": {{Scopes: []string{"orders:read"}}},
+		},
+	})
+
+	r := gin.New()
+	r.Use(authenticator.Handle(), authorizer.Handle())
+	r.Any("/api/*path", func(c *gin.Context) {
+		c.JSON(200, gin.H{"userId": c.GetString("userId"), "username": c.GetString("username")})
+	})
+
+	request := func(path, token string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	t.Run("ActiveToken", func(t *testing.T) {
+		status, body := request("/api/orders", "opaque-reader")
+		if status != 200 {
+			t.Fatalf("期望状态码 200，获得 %d %v", status, body)
+		}
+		if body["userId"] != "u-7" || body["username"] != "reader" {
+			t.Errorf("上下文中的用户信息不正确: %v", body)
+		}
+
+		// 结果被缓存
+		request("/api/orders", "opaque-reader")
+		if n := callCount("opaque-reader"); n != 1 {
+			t.Errorf("期望调用自省端点 1 次，实际 %d 次", n)
+		}
+	})
+
+	t.Run("ScopesFromIntrospection", func(t *testing.T) {
+		mu.Lock()
+		tokens["opaque-noscope"] = map[string]interface{}{"active": true, "sub": "u-10"}
+		mu.Unlock()
+		status, body := request("/api/orders", "opaque-noscope")
+		if status != 403 || body["code"] != "insufficient_scope" {
+			t.Errorf("缺少 scope 期望 403 insufficient_scope，获得 %d %v", status, body)
+		}
+	})
+
+	t.Run("InactiveToken", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			status, body := request("/api/orders", "opaque-unknown")
+			if status != 401 || body["code"] != "invalid_token" {
+				t.Fatalf("无效 token 期望 401 invalid_token，获得 %d %v", status, body)
+			}
+		}
+		if n := callCount("opaque-unknown"); n != 1 {
+			t.Errorf("无效结果应被缓存，实际调用 %d 次", n)
+		}
+	})
+
+	t.Run("CachedUntilExpiry", func(t *testing.T) {
+		if status, _ := request("/api/orders/x", "opaque-short"); status != 403 {
+			t.Fatalf("期望状态码 403 (缺少 scope)，获得 %d", status)
+		}
+		request("/api/mixed", "opaque-short")
+		if n := callCount("opaque-short"); n != 1 {
+			t.Errorf("过期前应使用缓存，实际调用 %d 次", n)
+		}
+
+		// 缓存随 token 过期，过期后重新自省，端点也不再返回 active
+		time.Sleep(1600 * time.Millisecond)
+		mu.Lock()
+		tokens["opaque-short"] = map[string]interface{}{"active": false}
+		mu.Unlock()
+		if status, _ := request("/api/mixed", "opaque-short"); status != 401 {
+			t.Errorf("过期后期望状态码 401，获得 %d", status)
+		}
+		if n := callCount("opaque-short"); n != 2 {
+			t.Errorf("过期后应重新自省，实际调用 %d 次", n)
+		}
+	})
+
+	t.Run("Audience", func(t *testing.T) {
+		if status, body := request("/api/strict", "opaque-other"); status != 401 || body["code"] != "invalid_audience" {
+			t.Errorf("audience 不匹配期望 401 invalid_audience，获得 %d %v", status, body)
+		}
+	})
+
+	t.Run("MixedWithJWT", func(t *testing.T) {
+		status, body := request("/api/mixed", jwtToken)
+		if status != 200 || body["userId"] != "u-1" {
+			t.Errorf("JWT 期望在本地校验，获得 %d %v", status, body)
+		}
+		if n := callCount(jwtToken); n != 0 {
+			t.Errorf("JWT 不应调用自省端点，实际调用 %d 次", n)
+		}
+
+		// 只接受自省的路由不在本地校验 JWT
+		if status, _ := request("/api/orders", jwtToken); status != 401 {
+			t.Errorf("只接受自省的路由期望状态码 401，获得 %d", status)
+		}
+	})
+
+	t.Run("EndpointDown", func(t *testing.T) {
+		mu.Lock()
+		down = true
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			down = false
+			mu.Unlock()
+		}()
+
+		status, body := request("/api/orders", "opaque-new")
+		if status != 503 || body["code"] != "introspection_unavailable" {
+			t.Errorf("自省端点不可用期望 503 introspection_unavailable，获得 %d %v", status, body)
+		}
+
+		// 缓存的结果不受影响
+		if status, _ := request("/api/orders", "opaque-reader"); status != 200 {
+			t.Errorf("已缓存的 token 期望状态码 200，获得 %d", status)
+		}
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		_, err := middleware.NewAuthenticator(config.AuthConfig{
+			Routes: map[string][]string{"/api": {"introspection"}},
+		}, jwtMiddleware)
+		if err == nil {
+			t.Error("未配置自省端点时期望创建失败")
+		}
+	})
+}
@@ -0,0 +1,365 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 模拟身份提供方的 JWKS 地址
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []map[string]string
+	failing bool
+	delay   time.Duration
+	hits    atomic.Int64
+}
+
+func newJWKSServer() *jwksServer {
+	s := &jwksServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+
+		s.mu.Lock()
+		delay := s.delay
+		s.mu.Unlock()
+		time.Sleep(delay)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	return s
+}
+
+// 替换 JWKS 中的密钥
+func (s *jwksServer) setKeys(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// 设置 JWKS 地址是否返回错误
+func (s *jwksServer) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+// 设置 JWKS 地址的响应延迟
+func (s *jwksServer) setDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+// 测试 JWKS：启动时获取、未知 kid 触发限速刷新、定时刷新、不可用时沿用上一次的密钥
+func TestJWKS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rsaKey1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaKey2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	idp := newJWKSServer()
+	defer idp.Close()
+	idp.setKeys(rsaJWK("rsa-1", &rsaKey1.PublicKey), ecJWK("ec-1", &ecKey.PublicKey), edJWK("ed-1", edPub))
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		Algorithms: []string{"RS256", "ES256", "EdDSA"},
+		JWKS: &config.JWKSConfig{
+			URL:                idp.URL,
+			RefreshInterval:    time.Hour,
+			MinRefreshInterval: 300 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+	defer jwtMiddleware.Close()
+
+	r := gin.New()
+	r.Use(jwtMiddleware.Handle())
+	r.GET("/api/me", func(c *gin.Context) {
+		c.String(200, c.GetString("userId"))
+	})
+	gateway := httptest.NewServer(r)
+	defer gateway.Close()
+
+	call := func(token string) int {
+		req, _ := http.NewRequest("GET", gateway.URL+"/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if hits := idp.hits.Load(); hits != 1 {
+		t.Fatalf("启动时应获取一次 JWKS，实际 %d 次", hits)
+	}
+
+	t.Run("CachedKeys", func(t *testing.T) {
+		tokens := []string{
+			signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey1),
+			signToken(t, jwt.SigningMethodES256, "ec-1", ecKey),
+			signToken(t, jwt.SigningMethodEdDSA, "ed-1", edKey),
+		}
+		for _, token := range tokens {
+			if status := call(token); status != 200 {
+				t.Errorf("期望状态码 200，获得 %d", status)
+			}
+		}
+		if hits := idp.hits.Load(); hits != 1 {
+			t.Errorf("已缓存的密钥不应重新获取 JWKS，实际 %d 次", hits)
+		}
+	})
+
+	t.Run("RefreshOnUnknownKID", func(t *testing.T) {
+		// 身份提供方轮换密钥
+		idp.setKeys(rsaJWK("rsa-1", &rsaKey1.PublicKey), rsaJWK("rsa-2", &rsaKey2.PublicKey))
+		time.Sleep(300 * time.Millisecond)
+
+		before := idp.hits.Load()
+		if status := call(signToken(t, jwt.SigningMethodRS256, "rsa-2", rsaKey2)); status != 200 {
+			t.Errorf("轮换后的密钥应可用，获得状态码 %d", status)
+		}
+		if hits := idp.hits.Load() - before; hits != 1 {
+			t.Errorf("未知 kid 应触发一次刷新，实际 %d 次", hits)
+		}
+	})
+
+	t.Run("RateLimitedRefresh", func(t *testing.T) {
+		time.Sleep(300 * time.Millisecond)
+
+		before := idp.hits.Load()
+		unknown := signToken(t, jwt.SigningMethodRS256, "rsa-unknown", rsaKey1)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if status := call(unknown); status != 401 {
+					t.Errorf("未知 kid 期望状态码 401，获得 %d", status)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if hits := idp.hits.Load() - before; hits != 1 {
+			t.Errorf("最小刷新间隔内应只刷新一次，实际 %d 次", hits)
+		}
+	})
+
+	t.Run("LastKnownGood", func(t *testing.T) {
+		idp.setFailing(true)
+		defer idp.setFailing(false)
+		time.Sleep(300 * time.Millisecond)
+
+		// 触发一次失败的刷新
+		before := idp.hits.Load()
+		if status := call(signToken(t, jwt.SigningMethodRS256, "rsa-3", rsaKey1)); status != 401 {
+			t.Errorf("未知 kid 期望状态码 401，获得 %d", status)
+		}
+		if hits := idp.hits.Load() - before; hits != 1 {
+			t.Errorf("期望尝试刷新一次，实际 %d 次", hits)
+		}
+
+		if status := call(signToken(t, jwt.SigningMethodRS256, "rsa-2", rsaKey2)); status != 200 {
+			t.Errorf("JWKS 不可用时应继续使用已缓存的密钥，获得状态码 %d", status)
+		}
+	})
+
+	t.Run("FailFastWhileRefreshing", func(t *testing.T) {
+		idp.setDelay(500 * time.Millisecond)
+		defer idp.setDelay(0)
+		time.Sleep(300 * time.Millisecond)
+
+		// 第一个请求触发刷新并等待身份提供方响应
+		refreshing := make(chan struct{})
+		go func() {
+			defer close(refreshing)
+			call(signToken(t, jwt.SigningMethodRS256, "rsa-4", rsaKey1))
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		// 刷新进行中时其他未知 kid 的请求直接失败，已缓存的密钥不受影响
+		start := time.Now()
+		if status := call(signToken(t, jwt.SigningMethodRS256, "rsa-5", rsaKey1)); status != 401 {
+			t.Errorf("未知 kid 期望状态码 401，获得 %d", status)
+		}
+		if status := call(signToken(t, jwt.SigningMethodRS256, "rsa-2", rsaKey2)); status != 200 {
+			t.Errorf("已缓存的密钥期望状态码 200，获得 %d", status)
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Errorf("刷新进行中时请求不应等待，耗时 %s", elapsed)
+		}
+		<-refreshing
+	})
+
+	t.Run("ScheduledRefresh", func(t *testing.T) {
+		scheduled, err := middleware.NewJWTMiddleware(config.JWTConfig{
+			Algorithms: []string{"RS256"},
+			JWKS:       &config.JWKSConfig{URL: idp.URL, RefreshInterval: 50 * time.Millisecond},
+		})
+		if err != nil {
+			t.Fatalf("创建 JWT 中间件失败: %v", err)
+		}
+
+		before := idp.hits.Load()
+		time.Sleep(275 * time.Millisecond)
+		if hits := idp.hits.Load() - before; hits < 3 {
+			t.Errorf("期望定时刷新至少 3 次，实际 %d 次", hits)
+		}
+
+		// 关闭后停止刷新
+		scheduled.Close()
+		before = idp.hits.Load()
+		time.Sleep(150 * time.Millisecond)
+		if hits := idp.hits.Load() - before; hits != 0 {
+			t.Errorf("关闭后不应继续刷新，实际 %d 次", hits)
+		}
+	})
+
+	t.Run("UnavailableAtStartup", func(t *testing.T) {
+		idp.setFailing(true)
+		defer idp.setFailing(false)
+
+		// 身份提供方不可用时网关仍可启动，恢复后按需获取密钥
+		lazy, err := middleware.NewJWTMiddleware(config.JWTConfig{
+			Algorithms: []string{"RS256"},
+			JWKS:       &config.JWKSConfig{URL: idp.URL, MinRefreshInterval: time.Millisecond},
+		})
+		if err != nil {
+			t.Fatalf("JWKS 不可用时不应启动失败: %v", err)
+		}
+		defer lazy.Close()
+
+		idp.setFailing(false)
+		time.Sleep(10 * time.Millisecond)
+
+		r := gin.New()
+		r.Use(lazy.Handle())
+		r.GET("/api/me", func(c *gin.Context) { c.String(200, "ok") })
+
+		req := httptest.NewRequest("GET", "/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey1))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Errorf("JWKS 恢复后期望状态码 200，获得 %d", w.Code)
+		}
+	})
+}
+
+// 测试首次获取失败后按最小刷新间隔在后台重试
+func TestJWKSRetryAfterFailedFetch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	idp := newJWKSServer()
+	defer idp.Close()
+	idp.setKeys(rsaJWK("rsa-1", &rsaKey.PublicKey))
+	idp.setFailing(true)
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		Algorithms: []string{"RS256"},
+		JWKS: &config.JWKSConfig{
+			URL:                idp.URL,
+			RefreshInterval:    time.Hour,
+			MinRefreshInterval: 50 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatalf("JWKS 不可用时不应启动失败: %v", err)
+	}
+	defer jwtMiddleware.Close()
+
+	time.Sleep(175 * time.Millisecond)
+	if hits := idp.hits.Load(); hits < 3 {
+		t.Errorf("获取失败后期望按最小刷新间隔重试，实际获取 %d 次", hits)
+	}
+
+	// 恢复后由后台获取密钥，请求不需要触发刷新
+	idp.setFailing(false)
+	time.Sleep(100 * time.Millisecond)
+	before := idp.hits.Load()
+
+	r := gin.New()
+	r.Use(jwtMiddleware.Handle())
+	r.GET("/api/me", func(c *gin.Context) { c.String(200, "ok") })
+
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("JWKS 恢复后期望状态码 200，获得 %d", w.Code)
+	}
+	if hits := idp.hits.Load() - before; hits != 0 {
+		t.Errorf("后台已获取密钥，请求不应再刷新，实际 %d 次", hits)
+	}
+}
+
+// 编码 RSA 公钥为 JWK
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// 编码 ECDSA 公钥为 JWK
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": key.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
+
+// 编码 Ed25519 公钥为 JWK
+func edJWK(kid string, key ed25519.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "OKP",
+		"kid": kid,
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(key),
+	}
+}
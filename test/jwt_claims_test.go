@@ -0,0 +1,122 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试 JWT 声明校验：iss、按路由的 aud、时钟偏差、必需声明以及区分失败原因的错误码
+func TestJWTClaimValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const secret = "claims-test-secret"
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		SecretKey: secret,
+		Issuer:    "https://idp.example.com",
+		Audience:  []string{"gateway"},
+		Leeway:    30 * time.Second,
+		RequiredClaims: map[string][]string{
+			"tenant": {"a", "b"},
+			"scope":  {},
+		},
+		Routes: map[string]config.JWTRouteConfig{
+			"/api/billing": {Audience: []string{"billing", "billing-admin"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(jwtMiddleware.Handle())
+	r.GET("/api/*path", func(c *gin.Context) {
+		c.String(200, c.GetString("userId"))
+	})
+
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"userId": "1",
+			"iss":    "https://idp.example.com",
+			"aud":    []string{"gateway"},
+			"exp":    now.Add(time.Hour).Unix(),
+			"tenant": "a",
+			"scope":  "read",
+		}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	sign := func(claims jwt.MapClaims, key string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		if err != nil {
+			t.Fatalf("签发 token 失败: %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+		code   string
+	}{
+		{"有效 token", "/api/users", "Bearer " + sign(valid(), secret), 200, ""},
+		{"缺少 token", "/api/users", "", 401, "token_missing"},
+		{"头部格式错误", "/api/users", "Token abc", 401, "token_malformed"},
+		{"无法解析", "/api/users", "Bearer not-a-jwt", 401, "token_malformed"},
+		{"签名错误", "/api/users", "Bearer " + sign(valid(), "other-secret"), 401, "invalid_signature"},
+		{"已过期", "/api/users", "Bearer " + sign(with("exp", now.Add(-time.Minute).Unix()), secret), 401, "token_expired"},
+		{"时钟偏差内过期", "/api/users", "Bearer " + sign(with("exp", now.Add(-10*time.Second).Unix()), secret), 200, ""},
+		{"尚未生效", "/api/users", "Bearer " + sign(with("nbf", now.Add(time.Minute).Unix()), secret), 401, "token_not_yet_valid"},
+		{"时钟偏差内尚未生效", "/api/users", "Bearer " + sign(with("nbf", now.Add(10*time.Second).Unix()), secret), 200, ""},
+		{"签发方错误", "/api/users", "Bearer " + sign(with("iss", "https://evil.example.com"), secret), 401, "invalid_issuer"},
+		{"缺少签发方", "/api/users", "Bearer " + sign(with("iss", nil), secret), 401, "invalid_claim"},
+		{"audience 错误", "/api/users", "Bearer " + sign(with("aud", "billing"), secret), 401, "invalid_audience"},
+		{"路由 audience", "/api/billing/invoices", "Bearer " + sign(with("aud", []string{"other", "billing-admin"}), secret), 200, ""},
+		{"路由 audience 覆盖全局", "/api/billing/invoices", "Bearer " + sign(valid(), secret), 401, "invalid_audience"},
+		{"缺少必需声明", "/api/users", "Bearer " + sign(with("scope", nil), secret), 401, "invalid_claim"},
+		{"声明值不在允许列表", "/api/users", "Bearer " + sign(with("tenant", "c"), secret), 401, "invalid_claim"},
+		{"数组声明包含允许值", "/api/users", "Bearer " + sign(with("tenant", []string{"c", "b"}), secret), 200, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("期望状态码 %d，获得 %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.code == "" {
+				return
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("解析响应失败: %v", err)
+			}
+			if body["code"] != tt.code {
+				t.Errorf("期望错误码 %s，获得 %s (%s)", tt.code, body["code"], body["error"])
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试 JWT 签名算法：RS256/ES256/EdDSA、kid 选择密钥、算法允许列表
+func TestJWTAlgorithms(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+
+	rsaOld, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaNew, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	rsaOldFile := writePublicKey(t, dir, "rsa-old", rsaOld.Public())
+	rsaNewFile := writePublicKey(t, dir, "rsa-new", rsaNew.Public())
+	ecFile := writePublicKey(t, dir, "ec", ecKey.Public())
+	edFile := writePublicKey(t, dir, "ed", edKey.Public())
+
+	// PKCS#1 格式的 RSA 公钥
+	pkcs1File := filepath.Join(dir, "rsa-pkcs1.pem")
+	writePEM(t, pkcs1File, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaNew.PublicKey))
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		Algorithms: []string{"RS256", "ES256", "EdDSA"},
+		Keys: []config.JWTKeyConfig{
+			{KID: "rsa-old", File: rsaOldFile},
+			{KID: "rsa-new", File: pkcs1File},
+			{KID: "ec", File: ecFile, Algorithm: "ES256"},
+			{KID: "ed", File: edFile},
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(jwtMiddleware.Handle())
+	r.GET("/api/me", func(c *gin.Context) {
+		c.String(200, c.GetString("userId"))
+	})
+	gateway := httptest.NewServer(r)
+	defer gateway.Close()
+
+	call := func(token string) int {
+		req, _ := http.NewRequest("GET", gateway.URL+"/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	rsaPublicPEM, err := os.ReadFile(rsaOldFile)
+	if err != nil {
+		t.Fatalf("读取公钥失败: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"RS256 旧密钥", signToken(t, jwt.SigningMethodRS256, "rsa-old", rsaOld), 200},
+		{"RS256 新密钥", signToken(t, jwt.SigningMethodRS256, "rsa-new", rsaNew), 200},
+		{"ES256", signToken(t, jwt.SigningMethodES256, "ec", ecKey), 200},
+		{"EdDSA", signToken(t, jwt.SigningMethodEdDSA, "ed", edKey), 200},
+		{"无 kid 时尝试所有匹配密钥", signToken(t, jwt.SigningMethodRS256, "", rsaNew), 200},
+		{"未知 kid", signToken(t, jwt.SigningMethodRS256, "rsa-unknown", rsaOld), 401},
+		{"kid 与签名密钥不匹配", signToken(t, jwt.SigningMethodRS256, "rsa-old", rsaNew), 401},
+		{"kid 指向其他类型的密钥", signToken(t, jwt.SigningMethodRS256, "ec", rsaOld), 401},
+		{"未启用的算法", signToken(t, jwt.SigningMethodRS384, "rsa-old", rsaOld), 401},
+		{"以公钥作为 HMAC 密钥", signToken(t, jwt.SigningMethodHS256, "rsa-old", rsaPublicPEM), 401},
+		{"none 算法", signToken(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType), 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := call(tt.token); status != tt.status {
+				t.Errorf("期望状态码 %d，获得 %d", tt.status, status)
+			}
+		})
+	}
+
+	// 只有公钥时网关不能签发 token
+	if _, err := jwtMiddleware.GenerateToken("1", "test"); err == nil {
+		t.Error("未启用 HMAC 算法时不应能签发 token")
+	}
+
+	// 非法配置
+	invalid := []struct {
+		name string
+		cfg  config.JWTConfig
+	}{
+		{"未知算法", config.JWTConfig{SecretKey: "secret", Algorithms: []string{"HS999"}}},
+		{"启用 none 算法", config.JWTConfig{Algorithms: []string{"none"}}},
+		{"HMAC 缺少共享密钥", config.JWTConfig{Algorithms: []string{"HS256"}}},
+		{"非对称算法缺少公钥", config.JWTConfig{Algorithms: []string{"RS256"}}},
+		{"重复的 kid", config.JWTConfig{
+			Algorithms: []string{"RS256"},
+			Keys:       []config.JWTKeyConfig{{KID: "a", File: rsaOldFile}, {KID: "a", File: rsaNewFile}},
+		}},
+		{"密钥类型与算法不符", config.JWTConfig{
+			Algorithms: []string{"RS256", "ES256"},
+			Keys:       []config.JWTKeyConfig{{KID: "a", File: rsaOldFile, Algorithm: "ES256"}},
+		}},
+		{"密钥算法未启用", config.JWTConfig{
+			Algorithms: []string{"RS256"},
+			Keys:       []config.JWTKeyConfig{{KID: "a", File: rsaOldFile, Algorithm: "RS512"}},
+		}},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := middleware.NewJWTMiddleware(tt.cfg); err == nil {
+				t.Error("期望配置校验失败")
+			}
+		})
+	}
+}
+
+// 以 PKIX 格式写入公钥
+func writePublicKey(t *testing.T, dir, name string, key crypto.PublicKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("序列化公钥失败: %v", err)
+	}
+
+	path := filepath.Join(dir, name+".pem")
+	writePEM(t, path, "PUBLIC KEY", der)
+	return path
+}
+
+// 使用指定算法与 kid 签发 token
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, &middleware.Claims{
+		UserID:   "1",
+		Username: "test",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("签发 token 失败: %v", err)
+	}
+	return signed
+}
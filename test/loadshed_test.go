@@ -0,0 +1,171 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试按优先级丢弃请求：全局并发上限、路由/声明/请求头指定类别，低优先级先被丢弃
+func TestLoadShedding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const secret = "loadshed-test-secret"
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{SecretKey: secret})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+
+	// 默认类别 critical、normal、low 分别可使用 10、8、5 个并发
+	shedder, err := middleware.NewLoadShedder(config.LoadShedConfig{
+		Enable:      true,
+		MaxInFlight: 10,
+		Routes: map[string]string{
+			"/api/batch":    "low",
+			"/api/payments": "critical",
+		},
+		Claim:  "priority",
+		Header: "X-Priority",
+	})
+	if err != nil {
+		t.Fatalf("创建负载保护失败: %v", err)
+	}
+
+	// /api/hold 的请求阻塞到 gate 关闭
+	gate := make(chan struct{})
+	r := gin.New()
+	r.Use(jwtMiddleware.Handle(), shedder.Handle())
+	r.GET("/api/*path", func(c *gin.Context) {
+		if c.Param("path") == "/hold" {
+			<-gate
+		}
+		c.String(200, "ok")
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("签发 token 失败: %v", err)
+		}
+		return token
+	}
+	plain := sign(jwt.MapClaims{"userId": "1"})
+	critical := sign(jwt.MapClaims{"userId": "2", "priority": "critical"})
+	low := sign(jwt.MapClaims{"userId": "3", "priority": "low"})
+
+	type response struct {
+		status int
+		class  string
+		code   string
+	}
+	get := func(path, token string, headers map[string]string) response {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("请求失败: %v", err)
+			return response{}
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		code, _ := body["code"].(string)
+		return response{resp.StatusCode, resp.Header.Get("X-Priority-Class"), code}
+	}
+
+	// 占用 n 个并发名额，直到 gate 关闭
+	var holders sync.WaitGroup
+	hold := func(n int) {
+		want := shedder.InFlight() + n
+		for i := 0; i < n; i++ {
+			holders.Add(1)
+			go func() {
+				defer holders.Done()
+				if resp := get("/api/hold", plain, nil); resp.status != 200 {
+					t.Errorf("占用名额的请求期望状态码 200, 实际 %d", resp.status)
+				}
+			}()
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for shedder.InFlight() < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待并发数达到 %d 超时, 实际 %d", want, shedder.InFlight())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	expect := func(name, path, token string, headers map[string]string, want response) {
+		t.Helper()
+		if got := get(path, token, headers); got != want {
+			t.Fatalf("%s: 期望 %+v, 实际 %+v", name, want, got)
+		}
+	}
+	shed := func(class string) response {
+		return response{503, class, "overloaded"}
+	}
+	ok := response{200, "", ""}
+
+	hold(5)
+	expect("low 类别达到上限", "/api/batch/run", plain, nil, shed("low"))
+	expect("normal 类别未达到上限", "/api/items", plain, nil, ok)
+	expect("请求头降低优先级", "/api/items", plain, map[string]string{"X-Priority": "low"}, shed("low"))
+
+	hold(3)
+	expect("normal 类别达到上限", "/api/items", plain, nil, shed("normal"))
+	expect("请求头不能提高优先级", "/api/items", plain, map[string]string{"X-Priority": "critical"}, shed("normal"))
+	expect("未知的类别被忽略", "/api/items", plain, map[string]string{"X-Priority": "urgent"}, shed("normal"))
+	expect("critical 路由", "/api/payments/charge", plain, nil, ok)
+	expect("声明提高优先级", "/api/items", critical, nil, ok)
+	expect("声明优先于路由", "/api/payments/charge", low, nil, shed("low"))
+
+	close(gate)
+	holders.Wait()
+	if n := shedder.InFlight(); n != 0 {
+		t.Fatalf("请求完成后期望并发数为 0, 实际 %d", n)
+	}
+	expect("负载恢复后接受 low 类别", "/api/batch/run", plain, nil, ok)
+
+	t.Run("Disabled", func(t *testing.T) {
+		shedder, err := middleware.NewLoadShedder(config.LoadShedConfig{})
+		if err != nil {
+			t.Fatalf("创建负载保护失败: %v", err)
+		}
+		r := gin.New()
+		r.Use(shedder.Handle())
+		r.GET("/api/items", func(c *gin.Context) { c.String(200, "ok") })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+		if w.Code != 200 {
+			t.Fatalf("未启用时期望状态码 200, 实际 %d", w.Code)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		for _, bad := range []config.LoadShedConfig{
+			{Enable: true},
+			{Enable: true, MaxInFlight: 10, DefaultClass: "urgent"},
+			{Enable: true, MaxInFlight: 10, Routes: map[string]string{"/api": "urgent"}},
+			{Enable: true, MaxInFlight: 10, DefaultClass: "high", Classes: []config.PriorityClassConfig{{Name: "high", Share: 0.5}, {Name: "low", Share: 0.8}}},
+			{Enable: true, MaxInFlight: 10, DefaultClass: "high", Classes: []config.PriorityClassConfig{{Name: "high", Share: 1.5}}},
+			{Enable: true, MaxInFlight: 10, DefaultClass: "high", Classes: []config.PriorityClassConfig{{Name: "high", Share: 1}, {Name: "high", Share: 1}}},
+		} {
+			if _, err := middleware.NewLoadShedder(bad); err == nil {
+				t.Fatalf("配置 %+v 应返回错误", bad)
+			}
+		}
+	})
+}
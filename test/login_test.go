@@ -0,0 +1,248 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/handler"
+	"github.com/ilukemagic/gogate/internal/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 测试登录：htpasswd 与上游认证服务两种凭证存储、按用户名与 IP 的失败锁定、token 有效期
+func TestLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+		SecretKey: "login-test-secret",
+		TokenTTL:  10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+
+	// htpasswd 文件，bcrypt 使用最低开销以加快测试
+	aliceHash, _ := bcrypt.GenerateFromPassword([]byte("alice-pw"), bcrypt.MinCost)
+	bobHash, _ := bcrypt.GenerateFromPassword([]byte("bob-pw"), bcrypt.MinCost)
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users\nalice:" + string(aliceHash) + ":u-1\nbob:" + string(bobHash) + "\n"
+	if err := os.WriteFile(htpasswd, []byte(content), 0600); err != nil {
+		t.Fatalf("写入 htpasswd 失败: %v", err)
+	}
+
+	// 上游认证服务
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+		switch {
+		case login["username"] == "broken":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case login["username"] == "carol" && login["password"] == "carol-pw":
+			json.NewEncoder(w).Encode(map[string]string{"userId": "u-3"})
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer authService.Close()
+
+	lockout := config.LockoutConfig{
+		MaxAttempts:      3,
+		MaxAttemptsPerIP: 5,
+		BaseDelay:        200 * time.Millisecond,
+		MaxDelay:         time.Second,
+	}
+
+	newGateway := func(credentials config.CredentialsConfig) *gin.Engine {
+		authHandler, err := handler.NewAuthHandler(config.AuthConfig{Credentials: credentials, Lockout: lockout}, jwtMiddleware)
+		if err != nil {
+			t.Fatalf("创建登录处理器失败: %v", err)
+		}
+
+		r := gin.New()
+		// 与网关默认配置一致，不信任任何代理
+		r.SetTrustedProxies(nil)
+		r.POST("/api/auth/login", authHandler.Login)
+		r.GET("/api/me", jwtMiddleware.Handle(), func(c *gin.Context) {
+			c.JSON(200, gin.H{"userId": c.GetString("userId"), "username": c.GetString("username")})
+		})
+		return r
+	}
+
+	type result struct {
+		status     int
+		body       map[string]interface{}
+		retryAfter string
+	}
+	// ip 为客户端的连接地址，forwardedFor 不为空时附带 X-Forwarded-For
+	loginVia := func(r *gin.Engine, ip, forwardedFor, username, password string) result {
+		payload, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return result{w.Code, body, w.Header().Get("Retry-After")}
+	}
+	login := func(r *gin.Engine, ip, username, password string) result {
+		return loginVia(r, ip, "", username, password)
+	}
+	me := func(r *gin.Engine, token string) map[string]interface{} {
+		req := httptest.NewRequest("GET", "/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("使用登录获得的 token 访问失败: %d %s", w.Code, w.Body.String())
+		}
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body
+	}
+
+	htpasswdGateway := newGateway(config.CredentialsConfig{Type: config.CredentialsHtpasswd, File: htpasswd})
+
+	t.Run("Htpasswd", func(t *testing.T) {
+		res := login(htpasswdGateway, "10.0.0.1", "alice", "alice-pw")
+		if res.status != 200 {
+			t.Fatalf("期望状态码 200，获得 %d: %v", res.status, res.body)
+		}
+		if res.body["expiresIn"] != float64(600) {
+			t.Errorf("期望 expiresIn 为 600，获得 %v", res.body["expiresIn"])
+		}
+		if user := me(htpasswdGateway, res.body["token"].(string)); user["userId"] != "u-1" || user["username"] != "alice" {
+			t.Errorf("token 中的用户信息不正确: %v", user)
+		}
+
+		// 未指定 userId 时使用用户名
+		res = login(htpasswdGateway, "10.0.0.1", "bob", "bob-pw")
+		if user := me(htpasswdGateway, res.body["token"].(string)); user["userId"] != "bob" {
+			t.Errorf("token 中的用户信息不正确: %v", user)
+		}
+
+		for _, tc := range [][2]string{{"alice", "wrong"}, {"nobody", "alice-pw"}} {
+			res := login(htpasswdGateway, "10.0.0.2", tc[0], tc[1])
+			if res.status != 401 || res.body["code"] != "invalid_credentials" {
+				t.Errorf("%s 期望 401 invalid_credentials，获得 %d %v", tc[0], res.status, res.body)
+			}
+		}
+
+		if res := login(htpasswdGateway, "10.0.0.1", "", ""); res.status != 400 {
+			t.Errorf("缺少用户名密码期望状态码 400，获得 %d", res.status)
+		}
+	})
+
+	t.Run("UsernameLockout", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			login(htpasswdGateway, "10.0.1."+strconv.Itoa(i), "bob", "wrong")
+		}
+
+		// 锁定期间即使密码正确也拒绝，且与来源 IP 无关
+		res := login(htpasswdGateway, "10.0.1.100", "bob", "bob-pw")
+		if res.status != 429 || res.body["code"] != "login_locked" {
+			t.Fatalf("期望 429 login_locked，获得 %d %v", res.status, res.body)
+		}
+		if res.retryAfter == "" {
+			t.Error("锁定响应缺少 Retry-After")
+		}
+
+		// 其他用户不受影响
+		if res := login(htpasswdGateway, "10.0.1.100", "alice", "alice-pw"); res.status != 200 {
+			t.Errorf("其他用户期望状态码 200，获得 %d", res.status)
+		}
+
+		// 锁定结束后可以登录
+		time.Sleep(250 * time.Millisecond)
+		if res := login(htpasswdGateway, "10.0.1.100", "bob", "bob-pw"); res.status != 200 {
+			t.Fatalf("锁定结束后期望状态码 200，获得 %d %v", res.status, res.body)
+		}
+
+		// 登录成功后计数清零，再次失败不会立即锁定
+		if res := login(htpasswdGateway, "10.0.1.100", "bob", "wrong"); res.status != 401 {
+			t.Errorf("计数清零后期望状态码 401，获得 %d", res.status)
+		}
+	})
+
+	t.Run("ExponentialBackoff", func(t *testing.T) {
+		gateway := newGateway(config.CredentialsConfig{Type: config.CredentialsHtpasswd, File: htpasswd})
+		for i := 0; i < 3; i++ {
+			login(gateway, "10.0.2."+strconv.Itoa(i), "alice", "wrong")
+		}
+		time.Sleep(250 * time.Millisecond)
+
+		// 锁定结束后再次失败，锁定时长翻倍
+		login(gateway, "10.0.2.10", "alice", "wrong")
+		time.Sleep(250 * time.Millisecond)
+		if res := login(gateway, "10.0.2.11", "alice", "alice-pw"); res.status != 429 {
+			t.Errorf("第二次锁定应更长，期望状态码 429，获得 %d", res.status)
+		}
+	})
+
+	t.Run("IPLockout", func(t *testing.T) {
+		gateway := newGateway(config.CredentialsConfig{Type: config.CredentialsHtpasswd, File: htpasswd})
+
+		// 同一 IP 尝试多个用户名
+		for i := 0; i < 5; i++ {
+			login(gateway, "10.0.3.1", "user"+strconv.Itoa(i), "wrong")
+		}
+		if res := login(gateway, "10.0.3.1", "alice", "alice-pw"); res.status != 429 {
+			t.Errorf("IP 锁定期间期望状态码 429，获得 %d", res.status)
+		}
+		// 不可信的客户端伪造 X-Forwarded-For 无法绕过锁定
+		if res := loginVia(gateway, "10.0.3.1", "10.0.3.99", "alice", "alice-pw"); res.status != 429 {
+			t.Errorf("伪造 X-Forwarded-For 期望状态码 429，获得 %d", res.status)
+		}
+		if res := login(gateway, "10.0.3.2", "alice", "alice-pw"); res.status != 200 {
+			t.Errorf("其他 IP 期望状态码 200，获得 %d", res.status)
+		}
+	})
+
+	t.Run("HTTPStore", func(t *testing.T) {
+		gateway := newGateway(config.CredentialsConfig{Type: config.CredentialsHTTP, URL: authService.URL})
+
+		res := login(gateway, "10.0.4.1", "carol", "carol-pw")
+		if res.status != 200 {
+			t.Fatalf("期望状态码 200，获得 %d: %v", res.status, res.body)
+		}
+		if user := me(gateway, res.body["token"].(string)); user["userId"] != "u-3" || user["username"] != "carol" {
+			t.Errorf("token 中的用户信息不正确: %v", user)
+		}
+
+		if res := login(gateway, "10.0.4.1", "carol", "wrong"); res.status != 401 {
+			t.Errorf("凭证错误期望状态码 401，获得 %d", res.status)
+		}
+
+		// 认证服务故障不计入失败次数
+		for i := 0; i < 5; i++ {
+			if res := login(gateway, "10.0.4.2", "broken", "pw"); res.status != 503 {
+				t.Fatalf("认证服务故障期望状态码 503，获得 %d", res.status)
+			}
+		}
+	})
+
+	t.Run("InvalidHtpasswd", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "htpasswd")
+		os.WriteFile(path, []byte("alice:$apr1$abc$def\n"), 0600)
+
+		_, err := handler.NewAuthHandler(config.AuthConfig{
+			Credentials: config.CredentialsConfig{Type: config.CredentialsHtpasswd, File: path},
+		}, jwtMiddleware)
+		if err == nil {
+			t.Error("非 bcrypt 哈希应加载失败")
+		}
+	})
+}
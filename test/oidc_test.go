@@ -0,0 +1,391 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 模拟 OpenID Connect 身份提供方，授权端点由测试直接签发授权码
+type oidcServer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	codes     map[string]oidcCode
+	codeCount int
+	badNonce  bool
+}
+
+type oidcCode struct {
+	challenge string
+	nonce     string
+}
+
+func newOIDCServer(t *testing.T) *oidcServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成 RSA 密钥失败: %v", err)
+	}
+
+	s := &oidcServer{key: key, codes: make(map[string]oidcCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{rsaJWK("idp-key", &key.PublicKey)}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if id, secret, ok := r.BasicAuth(); !ok || id != "dashboard" || secret != "client-secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+
+		// 授权码只能使用一次，且 code_verifier 必须与 code_challenge 对应
+		code, ok := s.codes[r.PostFormValue("code")]
+		delete(s.codes, r.PostFormValue("code"))
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		nonce := code.nonce
+		if s.badNonce {
+			nonce = "other-nonce"
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                s.URL,
+			"aud":                "dashboard",
+			"sub":                "u-42",
+			"preferred_username": "dana",
+			"roles":              []string{"admin"},
+			"nonce":              nonce,
+			"exp":                time.Now().Add(time.Hour).Unix(),
+		})
+		token.Header["kid"] = "idp-key"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "id_token": idToken, "token_type": "Bearer"})
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// 模拟用户在身份提供方登录，返回授权码
+func (s *oidcServer) authorize(t *testing.T, location string) (string, url.Values) {
+	u, err := url.Parse(location)
+	if err != nil || u.Path != "/authorize" {
+		t.Fatalf("期望跳转到授权端点，获得 %s", location)
+	}
+	params := u.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codeCount++
+	code := "code-" + strconv.Itoa(s.codeCount)
+	s.codes[code] = oidcCode{challenge: params.Get("code_challenge"), nonce: params.Get("nonce")}
+	return code, params
+}
+
+// 测试 OIDC 登录：跳转、回调、PKCE、加密会话 cookie 与登出
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	idp := newOIDCServer(t)
+	defer idp.Close()
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{SecretKey: "oidc-test-secret"})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+	bearer, _ := jwtMiddleware.GenerateToken("u-1", "alice")
+
+	newGateway := func(issuer string, jwtMiddleware *middleware.JWTMiddleware) *gin.Engine {
+		authenticator, err := middleware.NewAuthenticator(config.AuthConfig{
+			OIDC: &config.OIDCConfig{
+				Issuer:         issuer,
+				ClientID:       "dashboard",
+				ClientSecret:   "client-secret",
+				RedirectURL:    "https://gateway.example.com/auth/oidc/callback",
+				CookieSecret:   "0123456789abcdef0123456789abcdef",
+				InsecureCookie: true,
+			},
+			Routes: map[string][]string{"/public": {"none"}},
+		}, jwtMiddleware)
+		if err != nil {
+			t.Fatalf("创建认证中间件失败: %v", err)
+		}
+		t.Cleanup(authenticator.Close)
+
+		oidc := authenticator.OIDC()
+		r := gin.New()
+		r.GET(oidc.CallbackPath(), oidc.HandleCallback)
+		r.GET(oidc.LogoutPath(), oidc.HandleLogout)
+		r.Use(authenticator.Handle(), func(c *gin.Context) {
+			c.JSON(200, gin.H{
+				"userId":   c.GetString("userId"),
+				"username": c.GetString("username"),
+				"cookie":   c.GetHeader("Cookie"),
+			})
+			c.Abort()
+		})
+		return r
+	}
+	r := newGateway(idp.URL, jwtMiddleware)
+
+	type response struct {
+		status  int
+		body    map[string]interface{}
+		header  http.Header
+		cookies map[string]*http.Cookie
+	}
+	do := func(method, target string, header map[string]string, cookies ...*http.Cookie) response {
+		req := httptest.NewRequest(method, target, nil)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		res := response{status: w.Code, header: w.Header(), cookies: make(map[string]*http.Cookie)}
+		json.Unmarshal(w.Body.Bytes(), &res.body)
+		for _, cookie := range w.Result().Cookies() {
+			res.cookies[cookie.Name] = cookie
+		}
+		return res
+	}
+	browser := map[string]string{"Accept": "text/html,application/xhtml+xml"}
+
+	// 完成一次登录，返回会话 cookie
+	login := func(t *testing.T) *http.Cookie {
+		res := do("GET", "/dashboard?tab=1", browser)
+		code, params := idp.authorize(t, res.header.Get("Location"))
+		res = do("GET", "/auth/oidc/callback?code="+code+"&state="+params.Get("state"), nil, res.cookies["gogate_session_state"])
+		if res.status != 302 {
+			t.Fatalf("回调期望状态码 302，获得 %d %v", res.status, res.body)
+		}
+		return res.cookies["gogate_session"]
+	}
+
+	t.Run("RedirectToIdP", func(t *testing.T) {
+		res := do("GET", "/dashboard?tab=1", browser)
+		if res.status != 302 {
+			t.Fatalf("浏览器访问期望状态码 302，获得 %d %v", res.status, res.body)
+		}
+		_, params := idp.authorize(t, res.header.Get("Location"))
+		if params.Get("client_id") != "dashboard" || params.Get("response_type") != "code" ||
+			params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" ||
+			params.Get("redirect_uri") != "https://gateway.example.com/auth/oidc/callback" ||
+			params.Get("scope") != "openid profile email" || params.Get("nonce") == "" {
+			t.Errorf("授权请求参数不正确: %v", params)
+		}
+
+		state := res.cookies["gogate_session_state"]
+		if state == nil || !state.HttpOnly || state.Path != "/auth/oidc/callback" {
+			t.Errorf("state cookie 不正确: %v", state)
+		}
+
+		// API 调用不跳转
+		res = do("GET", "/dashboard", map[string]string{"Accept": "application/json"})
+		if res.status != 401 || res.body["code"] != "token_missing" {
+			t.Errorf("API 调用期望 401 token_missing，获得 %d %v", res.status, res.body)
+		}
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		res := do("GET", "/dashboard?tab=1", browser)
+		code, params := idp.authorize(t, res.header.Get("Location"))
+		stateCookie := res.cookies["gogate_session_state"]
+
+		res = do("GET", "/auth/oidc/callback?code="+code+"&state="+params.Get("state"), nil, stateCookie)
+		if res.status != 302 || res.header.Get("Location") != "/dashboard?tab=1" {
+			t.Fatalf("回调期望跳转回原地址，获得 %d %s %v", res.status, res.header.Get("Location"), res.body)
+		}
+		session := res.cookies["gogate_session"]
+		if session == nil || !session.HttpOnly || session.SameSite != http.SameSiteLaxMode {
+			t.Fatalf("会话 cookie 不正确: %v", session)
+		}
+		if cleared := res.cookies["gogate_session_state"]; cleared == nil || cleared.MaxAge >= 0 {
+			t.Error("回调后应清除 state cookie")
+		}
+
+		// 会话等同于有效 token，会话 cookie 不转发给上游
+		res = do("GET", "/api/orders", map[string]string{"Accept": "application/json"}, session, &http.Cookie{Name: "theme", Value: "dark"})
+		if res.status != 200 || res.body["userId"] != "u-42" || res.body["username"] != "dana" {
+			t.Fatalf("会话访问期望状态码 200 与用户信息，获得 %d %v", res.status, res.body)
+		}
+		if res.body["cookie"] != "theme=dark" {
+			t.Errorf("会话 cookie 不应转发给上游，获得 %v", res.body["cookie"])
+		}
+
+		// 同一组 state 与授权码不能重放
+		res = do("GET", "/auth/oidc/callback?code="+code+"&state="+params.Get("state"), nil, stateCookie)
+		if res.status != 401 {
+			t.Errorf("重放授权码期望状态码 401，获得 %d", res.status)
+		}
+	})
+
+	t.Run("SessionClaimsValidated", func(t *testing.T) {
+		// 会话同样需要满足必需声明，ID token 中没有 tenant
+		strict, err := middleware.NewJWTMiddleware(config.JWTConfig{
+			SecretKey:      "oidc-test-secret",
+			RequiredClaims: map[string][]string{"tenant": {"a", "b"}},
+		})
+		if err != nil {
+			t.Fatalf("创建 JWT 中间件失败: %v", err)
+		}
+		defer func(prev *gin.Engine) { r = prev }(r)
+		r = newGateway(idp.URL, strict)
+
+		session := login(t)
+		res := do("GET", "/api/orders", map[string]string{"Accept": "application/json"}, session)
+		if res.status != 401 || res.body["code"] != "invalid_claim" {
+			t.Errorf("缺少必需声明的会话期望 401 invalid_claim，获得 %d %v", res.status, res.body)
+		}
+
+		// 吊销用户的所有 token 后会话同样失效
+		isolated, err := middleware.NewJWTMiddleware(config.JWTConfig{SecretKey: "oidc-test-secret"})
+		if err != nil {
+			t.Fatalf("创建 JWT 中间件失败: %v", err)
+		}
+		r = newGateway(idp.URL, isolated)
+		session = login(t)
+		if res := do("GET", "/api/orders", map[string]string{"Accept": "application/json"}, session); res.status != 200 {
+			t.Fatalf("会话访问期望状态码 200，获得 %d %v", res.status, res.body)
+		}
+		token, _ := isolated.GenerateToken("u-42", "dana")
+		if err := isolated.Revoke(context.Background(), token, "", true); err != nil {
+			t.Fatalf("吊销失败: %v", err)
+		}
+		res = do("GET", "/api/orders", map[string]string{"Accept": "application/json"}, session)
+		if res.status != 401 || res.body["code"] != "token_revoked" {
+			t.Errorf("吊销后的会话期望 401 token_revoked，获得 %d %v", res.status, res.body)
+		}
+	})
+
+	t.Run("InvalidCallback", func(t *testing.T) {
+		res := do("GET", "/dashboard", browser)
+		code, params := idp.authorize(t, res.header.Get("Location"))
+		stateCookie := res.cookies["gogate_session_state"]
+
+		if res := do("GET", "/auth/oidc/callback?code="+code+"&state=forged", nil, stateCookie); res.status != 400 {
+			t.Errorf("state 不匹配期望状态码 400，获得 %d", res.status)
+		}
+		if res := do("GET", "/auth/oidc/callback?code="+code+"&state="+params.Get("state"), nil); res.status != 400 {
+			t.Errorf("缺少 state cookie 期望状态码 400，获得 %d", res.status)
+		}
+		if res := do("GET", "/auth/oidc/callback?error=access_denied&state="+params.Get("state"), nil, stateCookie); res.status != 401 {
+			t.Errorf("身份提供方拒绝期望状态码 401，获得 %d", res.status)
+		}
+
+		// ID token 的 nonce 与登录请求不一致
+		idp.mu.Lock()
+		idp.badNonce = true
+		idp.mu.Unlock()
+		defer func() {
+			idp.mu.Lock()
+			idp.badNonce = false
+			idp.mu.Unlock()
+		}()
+		res = do("GET", "/auth/oidc/callback?code="+code+"&state="+params.Get("state"), nil, stateCookie)
+		if res.status != 401 || res.cookies["gogate_session"] != nil {
+			t.Errorf("nonce 不匹配期望状态码 401 且不创建会话，获得 %d", res.status)
+		}
+	})
+
+	t.Run("TamperedSession", func(t *testing.T) {
+		session := login(t)
+		tampered := *session
+		tampered.Value = session.Value[:len(session.Value)-4] + "AAAA"
+
+		if res := do("GET", "/dashboard", browser, &tampered); res.status != 302 {
+			t.Errorf("篡改的会话期望跳转登录，获得 %d", res.status)
+		}
+		if res := do("GET", "/dashboard", nil, &tampered); res.status != 401 {
+			t.Errorf("篡改的会话期望状态码 401，获得 %d", res.status)
+		}
+
+		// state cookie 不能当作会话使用
+		res := do("GET", "/dashboard", browser)
+		state := res.cookies["gogate_session_state"]
+		if res := do("GET", "/dashboard", nil, &http.Cookie{Name: "gogate_session", Value: state.Value}); res.status != 401 {
+			t.Errorf("state cookie 当作会话期望状态码 401，获得 %d", res.status)
+		}
+	})
+
+	t.Run("BearerAndExcluded", func(t *testing.T) {
+		res := do("GET", "/dashboard", map[string]string{"Authorization": "Bearer " + bearer, "Accept": "text/html"})
+		if res.status != 200 || res.body["userId"] != "u-1" {
+			t.Errorf("Bearer token 期望状态码 200，获得 %d %v", res.status, res.body)
+		}
+		if res := do("GET", "/public/info", browser); res.status != 200 {
+			t.Errorf("无需认证的路由期望状态码 200，获得 %d", res.status)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		session := login(t)
+		res := do("GET", "/auth/oidc/logout", nil, session)
+		if res.status != 204 {
+			t.Fatalf("登出期望状态码 204，获得 %d", res.status)
+		}
+		if cleared := res.cookies["gogate_session"]; cleared == nil || cleared.MaxAge >= 0 {
+			t.Error("登出应清除会话 cookie")
+		}
+	})
+
+	t.Run("IdPUnavailable", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		gateway := newGateway(down.URL, jwtMiddleware)
+
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, req)
+		if w.Code != 503 {
+			t.Errorf("身份提供方不可用期望状态码 503，获得 %d", w.Code)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		configs := map[string]config.OIDCConfig{
+			"缺少 issuer":   {ClientID: "c", RedirectURL: "https://gw/cb", CookieSecret: "0123456789abcdef0123456789abcdef"},
+			"相对回调地址":      {Issuer: idp.URL, ClientID: "c", RedirectURL: "/cb", CookieSecret: "0123456789abcdef0123456789abcdef"},
+			"cookie 密钥过短": {Issuer: idp.URL, ClientID: "c", RedirectURL: "https://gw/cb", CookieSecret: "short"},
+		}
+		for name, cfg := range configs {
+			if _, err := middleware.NewAuthenticator(config.AuthConfig{OIDC: &cfg}, jwtMiddleware); err == nil {
+				t.Errorf("%s: 期望创建失败", name)
+			}
+		}
+	})
+}
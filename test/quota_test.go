@@ -0,0 +1,253 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试按调用方的每日与每月配额、管理接口以及基于文件的存储
+func TestQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.QuotaConfig{
+		Enable:     true,
+		Daily:      3,
+		Monthly:    5,
+		Tiers:      map[string]config.QuotaLimitConfig{"gold": {Monthly: 100}},
+		Consumers:  map[string]config.QuotaLimitConfig{"user:vip": {Daily: 10}},
+		AdminRoles: []string{"admin"},
+	}
+
+	newRouter := func(t *testing.T, quota *middleware.QuotaManager) *gin.Engine {
+		t.Helper()
+		r := gin.New()
+		admin := r.Group(quota.AdminPath())
+		admin.GET("/:consumer", quota.HandleView)
+		admin.DELETE("/:consumer", quota.HandleReset)
+
+		// 模拟认证中间件设置的身份
+		r.Use(func(c *gin.Context) {
+			if userID := c.GetHeader("X-Test-User"); userID != "" {
+				c.Set("userId", userID)
+			}
+			if name := c.GetHeader("X-Test-Consumer"); name != "" {
+				c.Set("consumer", &auth.Consumer{Name: name, Tier: c.GetHeader("X-Test-Tier")})
+			}
+		}, quota.Handle())
+		r.GET("/api/*path", func(c *gin.Context) { c.Status(200) })
+		return r
+	}
+
+	type response struct {
+		code       int
+		remaining  string
+		retryAfter string
+		errorCode  string
+	}
+	do := func(r *gin.Engine, method, path string, headers map[string]string) (response, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		errorCode, _ := body["code"].(string)
+		return response{w.Code, w.Header().Get("X-Quota-Remaining"), w.Header().Get("Retry-After"), errorCode}, w
+	}
+	expect := func(t *testing.T, r *gin.Engine, headers map[string]string, want response) {
+		t.Helper()
+		if got, _ := do(r, "GET", "/api/items", headers); got != want {
+			t.Fatalf("%v: 期望 %+v, 实际 %+v", headers, want, got)
+		}
+	}
+	view := func(t *testing.T, r *gin.Engine, consumer string) map[string]float64 {
+		t.Helper()
+		resp, w := do(r, "GET", "/admin/quotas/"+consumer, nil)
+		if resp.code != 200 {
+			t.Fatalf("查看配额期望状态码 200, 实际 %d", resp.code)
+		}
+		var body struct {
+			Quotas []struct {
+				Period string  `json:"period"`
+				Used   float64 `json:"used"`
+			} `json:"quotas"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		used := make(map[string]float64)
+		for _, q := range body.Quotas {
+			used[q.Period] = q.Used
+		}
+		return used
+	}
+
+	t.Run("DailyAndMonthly", func(t *testing.T) {
+		quota, err := middleware.NewQuotaManager(cfg)
+		if err != nil {
+			t.Fatalf("创建配额失败: %v", err)
+		}
+		clock := &fakeClock{now: time.Date(2026, 1, 30, 23, 0, 0, 0, time.UTC)}
+		quota.SetClock(clock)
+		r := newRouter(t, quota)
+		alice := map[string]string{"X-Test-User": "alice"}
+
+		expect(t, r, alice, response{200, "2", "", ""})
+		expect(t, r, alice, response{200, "1", "", ""})
+		expect(t, r, alice, response{200, "0", "", ""})
+		expect(t, r, alice, response{429, "0", "3600", "quota_exceeded"})
+
+		// 第二天每日配额重置，每月配额剩余 2
+		clock.Advance(time.Hour)
+		expect(t, r, alice, response{200, "1", "", ""})
+		expect(t, r, alice, response{200, "0", "", ""})
+		expect(t, r, alice, response{429, "0", "86400", "quota_exceeded"})
+
+		// 超出每月配额的请求退还已计入的每日用量
+		if used := view(t, r, "user:alice"); used["daily"] != 2 || used["monthly"] != 5 {
+			t.Fatalf("期望每日用量 2、每月用量 5, 实际 %v", used)
+		}
+
+		// 未认证的请求不计入配额
+		expect(t, r, nil, response{200, "", "", ""})
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		quota, _ := middleware.NewQuotaManager(cfg)
+		r := newRouter(t, quota)
+
+		// gold tier 不限制每日用量
+		gold := map[string]string{"X-Test-Consumer": "billing", "X-Test-Tier": "gold"}
+		for i := 0; i < 9; i++ {
+			do(r, "GET", "/api/items", gold)
+		}
+		expect(t, r, gold, response{200, "90", "", ""})
+
+		// 按调用方覆盖的配额优先，不限制每月用量
+		vip := map[string]string{"X-Test-User": "vip"}
+		for i := 0; i < 9; i++ {
+			do(r, "GET", "/api/items", vip)
+		}
+		expect(t, r, vip, response{200, "0", "", ""})
+		if got, _ := do(r, "GET", "/api/items", vip); got.code != 429 {
+			t.Fatalf("期望状态码 429, 实际 %d", got.code)
+		}
+	})
+
+	t.Run("AdminReset", func(t *testing.T) {
+		quota, _ := middleware.NewQuotaManager(cfg)
+		r := newRouter(t, quota)
+		bob := map[string]string{"X-Test-User": "bob"}
+		for i := 0; i < 3; i++ {
+			do(r, "GET", "/api/items", bob)
+		}
+		if got, _ := do(r, "GET", "/api/items", bob); got.code != 429 || got.errorCode != "quota_exceeded" {
+			t.Fatalf("期望配额用完, 实际 %+v", got)
+		}
+
+		if got, _ := do(r, "DELETE", "/admin/quotas/user:bob?period=yearly", nil); got.code != 400 {
+			t.Fatalf("未知的周期期望状态码 400, 实际 %d", got.code)
+		}
+		if got, _ := do(r, "DELETE", "/admin/quotas/user:bob?period=daily", nil); got.code != 204 {
+			t.Fatalf("重置配额期望状态码 204, 实际 %d", got.code)
+		}
+		if used := view(t, r, "user:bob"); used["daily"] != 0 || used["monthly"] != 3 {
+			t.Fatalf("重置每日用量后期望每日 0、每月 3, 实际 %v", used)
+		}
+		expect(t, r, bob, response{200, "1", "", ""})
+
+		if got, _ := do(r, "DELETE", "/admin/quotas/user:bob", nil); got.code != 204 {
+			t.Fatalf("重置配额期望状态码 204, 实际 %d", got.code)
+		}
+		if used := view(t, r, "user:bob"); used["daily"] != 0 || used["monthly"] != 0 {
+			t.Fatalf("重置后期望用量为 0, 实际 %v", used)
+		}
+	})
+
+	t.Run("FileStore", func(t *testing.T) {
+		fileCfg := cfg
+		fileCfg.Store = config.QuotaStoreConfig{Type: "file", Path: filepath.Join(t.TempDir(), "quota.json"), FlushInterval: time.Hour}
+
+		quota, err := middleware.NewQuotaManager(fileCfg)
+		if err != nil {
+			t.Fatalf("创建配额失败: %v", err)
+		}
+		r := newRouter(t, quota)
+		carol := map[string]string{"X-Test-User": "carol"}
+		do(r, "GET", "/api/items", carol)
+		do(r, "GET", "/api/items", carol)
+		if err := quota.Close(); err != nil {
+			t.Fatalf("关闭配额存储失败: %v", err)
+		}
+
+		// 重启后从文件恢复用量
+		quota, err = middleware.NewQuotaManager(fileCfg)
+		if err != nil {
+			t.Fatalf("重新创建配额失败: %v", err)
+		}
+		defer quota.Close()
+		r = newRouter(t, quota)
+		if used := view(t, r, "user:carol"); used["daily"] != 2 || used["monthly"] != 2 {
+			t.Fatalf("期望从文件恢复用量 2, 实际 %v", used)
+		}
+		expect(t, r, carol, response{200, "0", "", ""})
+	})
+
+	t.Run("SubjectOnlyToken", func(t *testing.T) {
+		// 身份提供方签发的 RS256 token 只有 sub，没有网关的 userId 声明
+		key, _ := rsa.GenerateKey(rand.Reader, 2048)
+		jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+			Algorithms: []string{"RS256"},
+			Keys:       []config.JWTKeyConfig{{KID: "idp", File: writePublicKey(t, t.TempDir(), "idp", key.Public())}},
+		})
+		if err != nil {
+			t.Fatalf("创建 JWT 中间件失败: %v", err)
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+			Subject:   "idp-user",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		})
+		token.Header["kid"] = "idp"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("签发 token 失败: %v", err)
+		}
+
+		quota, _ := middleware.NewQuotaManager(cfg)
+		r := gin.New()
+		r.Use(jwtMiddleware.Handle(), quota.Handle())
+		r.GET("/api/*path", func(c *gin.Context) { c.Status(200) })
+
+		bearer := map[string]string{"Authorization": "Bearer " + signed}
+		expect(t, r, bearer, response{200, "2", "", ""})
+		expect(t, r, bearer, response{200, "1", "", ""})
+		expect(t, r, bearer, response{200, "0", "", ""})
+		if got, _ := do(r, "GET", "/api/items", bearer); got.code != 429 || got.errorCode != "quota_exceeded" {
+			t.Fatalf("期望 429 quota_exceeded, 实际 %+v", got)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		for _, bad := range []config.QuotaConfig{
+			{Enable: true, Daily: 10},
+			{Enable: true, Daily: 10, AdminRoles: []string{"admin"}, Store: config.QuotaStoreConfig{Type: "redis"}},
+			{Enable: true, Daily: 10, AdminRoles: []string{"admin"}, Store: config.QuotaStoreConfig{Type: "file"}},
+		} {
+			if _, err := middleware.NewQuotaManager(bad); err == nil {
+				t.Fatalf("配置 %+v 应返回错误", bad)
+			}
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// 测试各限流算法在内存与 Redis 存储上的行为一致
+func TestRateLimitAlgorithms(t *testing.T) {
+	// 每一步先推进时钟，再依次发送请求
+	type step struct {
+		advance time.Duration
+		want    []bool
+	}
+
+	cases := []struct {
+		name   string
+		policy middleware.RateLimitPolicy
+		steps  []step
+	}{
+		{
+			name:   "TokenBucket",
+			policy: middleware.RateLimitPolicy{Algorithm: config.RateLimitAlgorithmTokenBucket, Rate: 1, Burst: 2},
+			steps: []step{
+				{0, []bool{true, true, false}},
+				{time.Second, []bool{true, false}},
+			},
+		},
+		{
+			name:   "GCRA",
+			policy: middleware.RateLimitPolicy{Algorithm: config.RateLimitAlgorithmGCRA, Rate: 2, Burst: 2},
+			steps: []step{
+				{0, []bool{true, true, false}},
+				{250 * time.Millisecond, []bool{false}},
+				{250 * time.Millisecond, []bool{true, false}},
+			},
+		},
+		{
+			name:   "FixedWindow",
+			policy: middleware.RateLimitPolicy{Algorithm: config.RateLimitAlgorithmFixedWindow, Limit: 2, Window: time.Minute},
+			steps: []step{
+				{0, []bool{true, true, false}},
+				{59 * time.Second, []bool{false}},
+				{time.Second, []bool{true, true, false}},
+			},
+		},
+		{
+			name:   "SlidingWindowLog",
+			policy: middleware.RateLimitPolicy{Algorithm: config.RateLimitAlgorithmSlidingWindowLog, Limit: 2, Window: time.Minute},
+			steps: []step{
+				{0, []bool{true}},
+				{30 * time.Second, []bool{true}},
+				{time.Second, []bool{false}},
+				{29 * time.Second, []bool{true, false}},
+				{31 * time.Second, []bool{true, false}},
+			},
+		},
+		{
+			name:   "SlidingWindowCounter",
+			policy: middleware.RateLimitPolicy{Algorithm: config.RateLimitAlgorithmSlidingWindowCounter, Limit: 4, Window: time.Minute},
+			steps: []step{
+				{0, []bool{true, true, true, true, false}},
+				{time.Minute, []bool{false}},
+				{30 * time.Second, []bool{true, true, false}},
+				{30 * time.Second, []bool{true, true, false}},
+			},
+		},
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	run := func(t *testing.T, backend middleware.RateLimitBackend, clock *fakeClock, setTime func(time.Time), policy middleware.RateLimitPolicy, steps []step) []middleware.RateLimitResult {
+		t.Helper()
+		var results []middleware.RateLimitResult
+		for i, s := range steps {
+			clock.Advance(s.advance)
+			setTime(clock.Now())
+			for j, want := range s.want {
+				result, err := backend.Take(context.Background(), "rule", "client", policy)
+				if err != nil {
+					t.Fatalf("第 %d 步第 %d 个请求出错: %v", i, j, err)
+				}
+				if result.Allowed != want {
+					t.Fatalf("第 %d 步第 %d 个请求期望 %v, 实际 %+v", i, j, want, result)
+				}
+				if !result.Allowed && result.RetryAfter <= 0 {
+					t.Fatalf("第 %d 步第 %d 个请求被拒绝时缺少重试时间: %+v", i, j, result)
+				}
+				results = append(results, result)
+			}
+		}
+		return results
+	}
+
+	for _, tc := range cases {
+		var memoryResults []middleware.RateLimitResult
+
+		t.Run(tc.name+"/Memory", func(t *testing.T) {
+			clock := &fakeClock{now: start}
+			backend := middleware.NewMemoryRateLimitBackend(0)
+			backend.SetClock(clock)
+			memoryResults = run(t, backend, clock, func(time.Time) {}, tc.policy, tc.steps)
+		})
+
+		// Redis 脚本与内存实现的结果一致，时间精确到毫秒
+		t.Run(tc.name+"/Redis", func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			backend, err := middleware.NewRedisRateLimitBackend(config.RedisConfig{Addr: mr.Addr()}, 0)
+			if err != nil {
+				t.Fatalf("创建 Redis 限流存储失败: %v", err)
+			}
+			defer backend.Close()
+			clock := &fakeClock{now: start}
+			results := run(t, backend, clock, mr.SetTime, tc.policy, tc.steps)
+
+			near := func(a, b time.Duration) bool {
+				d := a - b
+				return d > -time.Millisecond && d < time.Millisecond
+			}
+			for i, got := range results {
+				want := memoryResults[i]
+				if got.Limit != want.Limit || got.Remaining != want.Remaining ||
+					!near(got.Reset, want.Reset) || !near(got.RetryAfter, want.RetryAfter) {
+					t.Fatalf("第 %d 个请求 Redis 结果 %+v 与内存结果 %+v 不一致", i, got, want)
+				}
+			}
+		})
+
+		t.Run(tc.name+"/Limiter", func(t *testing.T) {
+			clock := &fakeClock{now: start}
+			limiter, err := middleware.NewLimiter(tc.policy, clock)
+			if err != nil {
+				t.Fatalf("创建限流器失败: %v", err)
+			}
+			for i, s := range tc.steps {
+				clock.Advance(s.advance)
+				for j, want := range s.want {
+					if allowed := limiter.Take().Allowed; allowed != want {
+						t.Fatalf("第 %d 步第 %d 个请求期望 %v, 实际 %v", i, j, want, allowed)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("PerRouteAlgorithm", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		rateLimiter, err := middleware.NewRateLimiter(config.RateLimitConfig{
+			Enable: true, Rate: 1000, Burst: 1000,
+			Routes: map[string]config.RateLimitRouteConfig{
+				"/api/reports": {Algorithm: "fixedWindow", Limit: 2, Window: 24 * time.Hour, Key: "ip"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("创建限流中间件失败: %v", err)
+		}
+		clock := &fakeClock{now: start.Add(23 * time.Hour)}
+		rateLimiter.SetClock(clock)
+
+		r := gin.New()
+		r.Use(rateLimiter.Handle())
+		r.Any("/*path", func(c *gin.Context) { c.Status(200) })
+		do := func() int {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/api/reports", nil))
+			return w.Code
+		}
+
+		codes := []int{do(), do(), do()}
+		if codes[0] != 200 || codes[1] != 200 || codes[2] != 429 {
+			t.Fatalf("期望状态码 [200 200 429], 实际 %v", codes)
+		}
+		// 每天的限额在 UTC 零点重置
+		clock.Advance(time.Hour)
+		if code := do(); code != 200 {
+			t.Fatalf("新的一天期望状态码 200, 实际 %d", code)
+		}
+	})
+
+	t.Run("InvalidPolicy", func(t *testing.T) {
+		for _, cfg := range []config.RateLimitConfig{
+			{Algorithm: "leakyBucket"},
+			{Algorithm: "gcra", Burst: 1},
+			{Algorithm: "gcra", Rate: 2_000_000_000, Burst: 1},
+			{Algorithm: "fixedWindow", Limit: 10},
+			{Routes: map[string]config.RateLimitRouteConfig{"/api": {Algorithm: "slidingWindowLog", Window: time.Minute}}},
+		} {
+			if _, err := middleware.NewRateLimiter(cfg); err == nil {
+				t.Fatalf("配置 %+v 应返回错误", cfg)
+			}
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试限流响应头与 Retry-After
+func TestRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	rateLimiter, err := middleware.NewRateLimiter(config.RateLimitConfig{
+		Enable: true, Rate: 1, Burst: 5,
+		Routes: map[string]config.RateLimitRouteConfig{
+			"/api/reports": {Algorithm: "fixedWindow", Limit: 2, Window: time.Minute},
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建限流中间件失败: %v", err)
+	}
+	rateLimiter.SetClock(&fakeClock{now: time.Date(2026, 1, 1, 0, 0, 15, 0, time.UTC)})
+
+	r := gin.New()
+	r.Use(rateLimiter.Handle())
+	r.Any("/*path", func(c *gin.Context) { c.Status(200) })
+
+	type want struct {
+		code       int
+		limit      string
+		remaining  string
+		reset      string
+		retryAfter string
+		scope      string
+	}
+	check := func(t *testing.T, path string, w want) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+		got := want{
+			code:       rec.Code,
+			limit:      rec.Header().Get("RateLimit-Limit"),
+			remaining:  rec.Header().Get("RateLimit-Remaining"),
+			reset:      rec.Header().Get("RateLimit-Reset"),
+			retryAfter: rec.Header().Get("Retry-After"),
+			scope:      rec.Header().Get("X-RateLimit-Scope"),
+		}
+		if got != w {
+			t.Fatalf("%s: 期望 %+v, 实际 %+v", path, w, got)
+		}
+
+		if rec.Code == 429 {
+			var body map[string]string
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body["code"] != "rate_limited" {
+				t.Fatalf("期望错误码 rate_limited, 实际 %v", body)
+			}
+		}
+	}
+
+	// 路由限流器剩余的请求数更少，响应头反映路由限流器
+	check(t, "/api/reports", want{200, "2", "1", "45", "", ""})
+	check(t, "/api/reports", want{200, "2", "0", "45", "", ""})
+	check(t, "/api/reports", want{429, "2", "0", "45", "45", "route:/api/reports"})
+
+	// 被路由限流器拒绝的请求不消耗全局令牌
+	check(t, "/api/other", want{200, "5", "2", "3", "", ""})
+	check(t, "/api/other", want{200, "5", "1", "4", "", ""})
+	check(t, "/api/other", want{200, "5", "0", "5", "", ""})
+	check(t, "/api/other", want{429, "5", "0", "5", "1", "global"})
+}
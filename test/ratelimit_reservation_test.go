@@ -0,0 +1,215 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试多个限流器的限额预留与退还，以及 exempt 与 include 路径规则
+func TestRateLimitReservation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 每种算法在两种存储上：拒绝后退还一个限额即可再通过一个请求
+	policies := map[string]middleware.RateLimitPolicy{
+		"TokenBucket":          {Rate: 1, Burst: 2},
+		"GCRA":                 {Algorithm: "gcra", Rate: 1, Burst: 2},
+		"FixedWindow":          {Algorithm: "fixedWindow", Limit: 2, Window: time.Minute},
+		"SlidingWindowLog":     {Algorithm: "slidingWindowLog", Limit: 2, Window: time.Minute},
+		"SlidingWindowCounter": {Algorithm: "slidingWindowCounter", Limit: 2, Window: time.Minute},
+	}
+	// 返回存储与推进其时钟的函数
+	backends := map[string]func(t *testing.T) (middleware.RateLimitBackend, func(time.Duration)){
+		"Memory": func(t *testing.T) (middleware.RateLimitBackend, func(time.Duration)) {
+			clock := &fakeClock{now: start}
+			backend := middleware.NewMemoryRateLimitBackend(0)
+			backend.SetClock(clock)
+			return backend, clock.Advance
+		},
+		"Redis": func(t *testing.T) (middleware.RateLimitBackend, func(time.Duration)) {
+			mr := miniredis.RunT(t)
+			now := start
+			mr.SetTime(now)
+			backend, err := middleware.NewRedisRateLimitBackend(config.RedisConfig{Addr: mr.Addr()}, 0)
+			if err != nil {
+				t.Fatalf("创建 Redis 限流存储失败: %v", err)
+			}
+			t.Cleanup(func() { backend.Close() })
+			return backend, func(d time.Duration) {
+				now = now.Add(d)
+				mr.SetTime(now)
+			}
+		},
+	}
+	for name, policy := range policies {
+		for backendName, newBackend := range backends {
+			t.Run(name+"/"+backendName, func(t *testing.T) {
+				backend, _ := newBackend(t)
+				ctx := context.Background()
+				take := func() middleware.RateLimitResult {
+					result, err := backend.Take(ctx, "rule", "client", policy)
+					if err != nil {
+						t.Fatalf("限流出错: %v", err)
+					}
+					return result
+				}
+
+				take()
+				second := take()
+				if !second.Allowed || second.Remaining != 0 {
+					t.Fatalf("第二个请求期望通过且剩余 0, 实际 %+v", second)
+				}
+				if take().Allowed {
+					t.Fatal("限额用完后请求应被拒绝")
+				}
+				if err := backend.Refund(ctx, "rule", "client", policy, second.Reservation); err != nil {
+					t.Fatalf("退还限额出错: %v", err)
+				}
+				if result := take(); !result.Allowed {
+					t.Fatalf("退还后请求期望通过, 实际 %+v", result)
+				}
+				if take().Allowed {
+					t.Fatal("再次用完后请求应被拒绝")
+				}
+			})
+		}
+	}
+
+	// 滑动窗口日志只退还自己的记录，而不是最近的一条
+	for backendName, newBackend := range backends {
+		t.Run("SlidingWindowLogRefundOwnEntry/"+backendName, func(t *testing.T) {
+			backend, advance := newBackend(t)
+			ctx := context.Background()
+			policy := policies["SlidingWindowLog"]
+			take := func() middleware.RateLimitResult {
+				result, err := backend.Take(ctx, "rule", "client", policy)
+				if err != nil {
+					t.Fatalf("限流出错: %v", err)
+				}
+				return result
+			}
+
+			first := take()
+			advance(30 * time.Second)
+			take()
+			if err := backend.Refund(ctx, "rule", "client", policy, first.Reservation); err != nil {
+				t.Fatalf("退还限额出错: %v", err)
+			}
+			if !take().Allowed {
+				t.Fatal("退还后请求期望通过")
+			}
+			// 窗口内剩下 30s 与当前的两条记录，最早的一条 60s 后移出窗口
+			result := take()
+			if result.Allowed || result.RetryAfter != time.Minute {
+				t.Fatalf("期望被拒绝且 60s 后重试, 实际 %+v", result)
+			}
+		})
+	}
+
+	newRouter := func(t *testing.T, cfg config.RateLimitConfig, clock middleware.Clock) *gin.Engine {
+		t.Helper()
+		cfg.Enable = true
+		rateLimiter, err := middleware.NewRateLimiter(cfg)
+		if err != nil {
+			t.Fatalf("创建限流中间件失败: %v", err)
+		}
+		if clock != nil {
+			rateLimiter.SetClock(clock)
+		}
+		r := gin.New()
+		r.Use(rateLimiter.Handle())
+		r.Any("/*path", func(c *gin.Context) { c.Status(200) })
+		return r
+	}
+
+	do := func(r *gin.Engine, path string) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Header().Get("X-RateLimit-Scope")
+	}
+
+	// 全局限流器拒绝时退还路由限流器的令牌，路由的 5 个令牌全部可用
+	t.Run("RefundOnGlobalRejection", func(t *testing.T) {
+		clock := &fakeClock{now: start}
+		r := newRouter(t, config.RateLimitConfig{
+			Rate: 1, Burst: 1,
+			Routes: map[string]config.RateLimitRouteConfig{"/api/reports": {Rate: 0, Burst: 5}},
+		}, clock)
+
+		allowed := 0
+		for i := 0; i < 3; i++ {
+			code, scope := do(r, "/api/reports")
+			if code == 200 {
+				allowed++
+			} else if scope != "global" {
+				t.Fatalf("期望被全局限流器拒绝, 实际 %q", scope)
+			}
+		}
+		for i := 0; i < 10; i++ {
+			clock.Advance(time.Second)
+			code, scope := do(r, "/api/reports")
+			if code == 200 {
+				allowed++
+			} else if scope != "route:/api/reports" {
+				t.Fatalf("全局令牌恢复后期望被路由限流器拒绝, 实际 %q", scope)
+			}
+		}
+		if allowed != 5 {
+			t.Fatalf("路由限额为 5, 实际通过 %d 个请求", allowed)
+		}
+	})
+
+	t.Run("DefaultExemptHealth", func(t *testing.T) {
+		r := newRouter(t, config.RateLimitConfig{Rate: 0, Burst: 1}, nil)
+		for i := 0; i < 3; i++ {
+			if code, _ := do(r, "/health"); code != 200 {
+				t.Fatalf("/health 默认不限流, 实际状态码 %d", code)
+			}
+		}
+		do(r, "/api/a")
+		if code, _ := do(r, "/api/a"); code != 429 {
+			t.Fatalf("期望状态码 429, 实际 %d", code)
+		}
+	})
+
+	t.Run("HealthAlwaysExempt", func(t *testing.T) {
+		// 配置其他 exempt 路径时 /health 同样不限流
+		r := newRouter(t, config.RateLimitConfig{Rate: 0, Burst: 1, Exempt: []string{"/metrics"}}, nil)
+		for _, path := range []string{"/health", "/health", "/metrics", "/metrics"} {
+			if code, _ := do(r, path); code != 200 {
+				t.Fatalf("%s 不应限流, 实际状态码 %d", path, code)
+			}
+		}
+	})
+
+	t.Run("ExemptAndInclude", func(t *testing.T) {
+		r := newRouter(t, config.RateLimitConfig{
+			Rate: 0, Burst: 1,
+			Exempt:  []string{"/api/public", "/api/auth/login"},
+			Include: []string{"/api", "/api/public/search"},
+		}, nil)
+
+		// 未包含的路径与豁免的路径不限流
+		for _, path := range []string{"/health", "/static/app.js", "/api/public/docs", "/api/auth/login", "/api/public/docs"} {
+			if code, _ := do(r, path); code != 200 {
+				t.Fatalf("%s 不应限流, 实际状态码 %d", path, code)
+			}
+		}
+
+		// 较长的 include 前缀覆盖 exempt
+		if code, _ := do(r, "/api/public/search"); code != 200 {
+			t.Fatalf("期望状态码 200, 实际 %d", code)
+		}
+		if code, _ := do(r, "/api/orders"); code != 429 {
+			t.Fatalf("期望状态码 429, 实际 %d", code)
+		}
+	})
+}
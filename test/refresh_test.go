@@ -0,0 +1,260 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/auth"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/handler"
+	"github.com/ilukemagic/gogate/internal/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 总是失败的吊销存储
+type failingRevocationStore struct{}
+
+func (failingRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	return false, errors.New("store down")
+}
+
+func (failingRevocationStore) RevokeUser(ctx context.Context, userID string, before time.Time, ttl time.Duration) error {
+	return errors.New("store down")
+}
+
+func (failingRevocationStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	return false, errors.New("store down")
+}
+
+// 测试刷新 token 轮换、重复使用检测、登出与按用户吊销
+func TestRefreshAndRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("alice-pw"), bcrypt.MinCost)
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("alice:"+string(hash)+":u-1\n"), 0600); err != nil {
+		t.Fatalf("写入 htpasswd 失败: %v", err)
+	}
+
+	newGateway := func() (*gin.Engine, *middleware.JWTMiddleware) {
+		jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{
+			SecretKey:  "refresh-test-secret",
+			TokenTTL:   5 * time.Minute,
+			RefreshTTL: time.Hour,
+		})
+		if err != nil {
+			t.Fatalf("创建 JWT 中间件失败: %v", err)
+		}
+		authHandler, err := handler.NewAuthHandler(config.AuthConfig{
+			Credentials: config.CredentialsConfig{Type: config.CredentialsHtpasswd, File: htpasswd},
+		}, jwtMiddleware)
+		if err != nil {
+			t.Fatalf("创建登录处理器失败: %v", err)
+		}
+
+		r := gin.New()
+		r.POST("/api/auth/login", authHandler.Login)
+		r.POST("/api/auth/refresh", authHandler.Refresh)
+		r.POST("/api/auth/logout", authHandler.Logout)
+		r.GET("/api/me", jwtMiddleware.Handle(), func(c *gin.Context) {
+			c.JSON(200, gin.H{"userId": c.GetString("userId")})
+		})
+		return r, jwtMiddleware
+	}
+
+	post := func(r *gin.Engine, path, token string, body interface{}) (int, map[string]interface{}) {
+		var payload []byte
+		if body != nil {
+			payload, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest("POST", path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var res map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res
+	}
+	login := func(r *gin.Engine) (string, string) {
+		status, body := post(r, "/api/auth/login", "", map[string]string{"username": "alice", "password": "alice-pw"})
+		if status != 200 {
+			t.Fatalf("登录失败: %d %v", status, body)
+		}
+		if body["expiresIn"] != float64(300) {
+			t.Errorf("期望 expiresIn 为 300，获得 %v", body["expiresIn"])
+		}
+		return body["token"].(string), body["refreshToken"].(string)
+	}
+	refresh := func(r *gin.Engine, refreshToken string) (int, map[string]interface{}) {
+		return post(r, "/api/auth/refresh", "", map[string]string{"refreshToken": refreshToken})
+	}
+	me := func(r *gin.Engine, token string) (int, string) {
+		req := httptest.NewRequest("GET", "/api/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		code, _ := body["code"].(string)
+		return w.Code, code
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		r, _ := newGateway()
+		_, refreshToken := login(r)
+
+		status, body := refresh(r, refreshToken)
+		if status != 200 {
+			t.Fatalf("刷新失败: %d %v", status, body)
+		}
+		if body["refreshToken"] == refreshToken {
+			t.Error("刷新后应签发新的刷新 token")
+		}
+		if status, _ := me(r, body["token"].(string)); status != 200 {
+			t.Errorf("新的访问 token 期望状态码 200，获得 %d", status)
+		}
+
+		// 新的刷新 token 可以继续使用
+		if status, _ := refresh(r, body["refreshToken"].(string)); status != 200 {
+			t.Errorf("新的刷新 token 期望状态码 200，获得 %d", status)
+		}
+	})
+
+	t.Run("ReuseRevokesUser", func(t *testing.T) {
+		r, _ := newGateway()
+		access, refreshToken := login(r)
+		_, rotated := refresh(r, refreshToken)
+
+		// 旧的刷新 token 再次使用，吊销该用户的所有 token
+		status, body := refresh(r, refreshToken)
+		if status != 401 || body["code"] != "token_revoked" {
+			t.Fatalf("重复使用期望 401 token_revoked，获得 %d %v", status, body)
+		}
+		if status, code := me(r, access); status != 401 || code != "token_revoked" {
+			t.Errorf("访问 token 应被吊销，获得 %d %s", status, code)
+		}
+		if status, _ := refresh(r, rotated["refreshToken"].(string)); status != 401 {
+			t.Errorf("轮换得到的刷新 token 应被吊销，获得 %d", status)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		r, _ := newGateway()
+		access, refreshToken := login(r)
+		otherAccess, otherRefresh := login(r)
+
+		if status, body := post(r, "/api/auth/logout", access, map[string]string{"refreshToken": refreshToken}); status != 204 {
+			t.Fatalf("登出期望状态码 204，获得 %d %v", status, body)
+		}
+		if status, code := me(r, access); status != 401 || code != "token_revoked" {
+			t.Errorf("登出后访问 token 应被吊销，获得 %d %s", status, code)
+		}
+
+		// 同一用户的其他会话不受影响
+		if status, _ := me(r, otherAccess); status != 200 {
+			t.Errorf("其他会话期望状态码 200，获得 %d", status)
+		}
+
+		// 请求体可以为空
+		if status, _ := post(r, "/api/auth/logout", otherAccess, nil); status != 204 {
+			t.Errorf("无请求体登出期望状态码 204，获得 %d", status)
+		}
+		if status, _ := me(r, otherAccess); status != 401 {
+			t.Errorf("登出后访问 token 应被吊销，获得 %d", status)
+		}
+		if status, _ := refresh(r, otherRefresh); status != 200 {
+			t.Errorf("未吊销的刷新 token 期望状态码 200，获得 %d", status)
+		}
+
+		if status, _ := post(r, "/api/auth/logout", "", nil); status != 401 {
+			t.Errorf("缺少访问 token 期望状态码 401，获得 %d", status)
+		}
+		if status, _ := post(r, "/api/auth/logout", access, nil); status != 401 {
+			t.Errorf("已吊销的访问 token 登出期望状态码 401，获得 %d", status)
+		}
+
+		// 登出后仍使用刷新 token 视为泄露，与重复使用同样处理
+		if status, body := refresh(r, refreshToken); status != 401 || body["code"] != "token_revoked" {
+			t.Errorf("登出后刷新 token 应被吊销，获得 %d %v", status, body)
+		}
+	})
+
+	t.Run("LogoutAll", func(t *testing.T) {
+		r, _ := newGateway()
+		access, _ := login(r)
+		otherAccess, otherRefresh := login(r)
+
+		if status, _ := post(r, "/api/auth/logout", access, map[string]bool{"all": true}); status != 204 {
+			t.Fatalf("登出期望状态码 204，获得 %d", status)
+		}
+		if status, code := me(r, otherAccess); status != 401 || code != "token_revoked" {
+			t.Errorf("其他会话应被吊销，获得 %d %s", status, code)
+		}
+		if status, _ := refresh(r, otherRefresh); status != 401 {
+			t.Errorf("其他会话的刷新 token 应被吊销，获得 %d", status)
+		}
+
+		// 同一秒内重新登录获得的 token 不受影响
+		access, refreshToken := login(r)
+		if status, _ := me(r, access); status != 200 {
+			t.Errorf("重新登录后期望状态码 200，获得 %d", status)
+		}
+		if status, _ := refresh(r, refreshToken); status != 200 {
+			t.Errorf("重新登录后的刷新 token 期望状态码 200，获得 %d", status)
+		}
+	})
+
+	t.Run("TokenTypes", func(t *testing.T) {
+		r, _ := newGateway()
+		access, refreshToken := login(r)
+
+		// 刷新 token 不能用作访问 token，反之亦然
+		if status, code := me(r, refreshToken); status != 401 || code != "invalid_token" {
+			t.Errorf("刷新 token 访问期望 401 invalid_token，获得 %d %s", status, code)
+		}
+		if status, body := refresh(r, access); status != 401 || body["code"] != "invalid_token" {
+			t.Errorf("访问 token 刷新期望 401 invalid_token，获得 %d %v", status, body)
+		}
+		if status, _ := refresh(r, ""); status != 400 {
+			t.Errorf("缺少刷新 token 期望状态码 400，获得 %d", status)
+		}
+	})
+
+	t.Run("CustomStore", func(t *testing.T) {
+		r, jwtMiddleware := newGateway()
+		access, refreshToken := login(r)
+
+		// 吊销记录保存在替换后的存储中
+		store := auth.NewMemoryRevocationStore()
+		jwtMiddleware.SetRevocationStore(store)
+		if status, _ := post(r, "/api/auth/logout", access, map[string]string{"refreshToken": refreshToken}); status != 204 {
+			t.Fatalf("登出期望状态码 204，获得 %d", status)
+		}
+		if revoked, _ := store.IsRevoked(context.Background(), "", "u-1", time.Now()); revoked {
+			t.Error("未请求时不应吊销用户的所有 token")
+		}
+
+		// 存储不可用时拒绝请求
+		access, refreshToken = login(r)
+		jwtMiddleware.SetRevocationStore(failingRevocationStore{})
+		if status, _ := me(r, access); status != 401 {
+			t.Errorf("存储不可用时期望状态码 401，获得 %d", status)
+		}
+		if status, _ := refresh(r, refreshToken); status != 503 {
+			t.Errorf("存储不可用时刷新期望状态码 503，获得 %d", status)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/server"
+)
+
+// 测试优雅关闭：健康检查摘除、请求排空、关闭钩子与超时中断
+func TestGracefulShutdown(t *testing.T) {
+	newServer := func(slow time.Duration) (*server.Server, string) {
+		mux := http.NewServeMux()
+		var srv *server.Server
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			if !srv.Healthy() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(slow)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("监听失败: %v", err)
+		}
+		srv = server.New(config.ProxyConfig{}, mux)
+		go srv.Serve(ln)
+
+		return srv, "http://" + ln.Addr().String()
+	}
+
+	t.Run("DrainInFlight", func(t *testing.T) {
+		srv, baseURL := newServer(400 * time.Millisecond)
+
+		hookCalled := make(chan struct{})
+		srv.OnShutdown("test hook", func(ctx context.Context) error {
+			close(hookCalled)
+			return nil
+		})
+
+		// 发起一个慢请求，在其处理期间开始关闭
+		result := make(chan int, 1)
+		go func() {
+			resp, err := http.Get(baseURL + "/slow")
+			if err != nil {
+				result <- 0
+				return
+			}
+			resp.Body.Close()
+			result <- resp.StatusCode
+		}()
+		waitFor(t, func() bool { return srv.InFlight() == 1 })
+
+		done := make(chan server.DrainSummary, 1)
+		go func() {
+			done <- srv.GracefulShutdown(config.ShutdownConfig{
+				PreStopDelay: 200 * time.Millisecond,
+				DrainTimeout: 2 * time.Second,
+			})
+		}()
+
+		// preStop 期间健康检查失败，但仍然接受请求
+		waitFor(t, func() bool { return !srv.Healthy() })
+		resp, err := http.Get(baseURL + "/health")
+		if err != nil {
+			t.Fatalf("preStop 期间请求失败: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("期望健康检查返回 503，获得 %d", resp.StatusCode)
+		}
+
+		if code := <-result; code != http.StatusOK {
+			t.Fatalf("进行中的请求应正常完成，获得状态码 %d", code)
+		}
+
+		summary := <-done
+		if summary.InFlight != 1 || summary.Drained != 1 || summary.Aborted != 0 {
+			t.Fatalf("排空结果不符合预期: %s", summary)
+		}
+		if len(summary.Errors) != 0 {
+			t.Fatalf("关闭过程中出现错误: %v", summary.Errors)
+		}
+
+		select {
+		case <-hookCalled:
+		default:
+			t.Fatal("关闭钩子未执行")
+		}
+
+		// 关闭后不再接受新连接
+		if _, err := http.Get(baseURL + "/health"); err == nil {
+			t.Fatal("关闭后仍然接受新连接")
+		}
+	})
+
+	t.Run("DrainTimeout", func(t *testing.T) {
+		srv, baseURL := newServer(3 * time.Second)
+
+		go func() {
+			resp, err := http.Get(baseURL + "/slow")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		waitFor(t, func() bool { return srv.InFlight() == 1 })
+
+		start := time.Now()
+		summary := srv.GracefulShutdown(config.ShutdownConfig{DrainTimeout: 200 * time.Millisecond})
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("超时后应强制关闭，实际耗时 %s", elapsed)
+		}
+		if summary.Aborted != 1 {
+			t.Fatalf("期望 1 个请求被中断: %s", summary)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/proxy"
+)
+
+// 测试 SSE 等流式响应：事件立即送达、不受总超时限制、空闲超时仍然生效
+func TestStreamingProxy(t *testing.T) {
+	// 模拟 SSE 服务：立即发送第一个事件，间隔 delay 后发送第二个事件
+	newSSEServer := func(delay time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: first\n\n")
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+
+			fmt.Fprint(w, "data: second\n\n")
+		}))
+	}
+
+	t.Run("ImmediateFlush", func(t *testing.T) {
+		upstream := newSSEServer(500 * time.Millisecond)
+		defer upstream.Close()
+
+		gateway := newProxyServer(t, config.RouteConfig{
+			Targets:   []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			Streaming: true,
+		})
+		defer gateway.Close()
+
+		start := time.Now()
+		resp, err := http.Get(gateway.URL + "/api/events")
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.Header.Get("X-Accel-Buffering") != "no" {
+			t.Fatalf("期望流式响应禁用缓冲")
+		}
+
+		events := readEvents(resp)
+		if event := <-events; event != "first" {
+			t.Fatalf("期望第一个事件为 first，获得 %q", event)
+		}
+		if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+			t.Fatalf("第一个事件应立即送达，实际耗时 %s", elapsed)
+		}
+		if event := <-events; event != "second" {
+			t.Fatalf("期望第二个事件为 second，获得 %q", event)
+		}
+	})
+
+	t.Run("ExemptFromTimeout", func(t *testing.T) {
+		upstream := newSSEServer(300 * time.Millisecond)
+		defer upstream.Close()
+
+		gateway := newProxyServer(t, config.RouteConfig{
+			Targets:   []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			Streaming: true,
+			Timeout:   100 * time.Millisecond,
+		})
+		defer gateway.Close()
+
+		resp, err := http.Get(gateway.URL + "/api/events")
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var got []string
+		for event := range readEvents(resp) {
+			got = append(got, event)
+		}
+		if strings.Join(got, ",") != "first,second" {
+			t.Fatalf("流式路由不应受总超时限制，收到事件 %v", got)
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		upstream := newSSEServer(2 * time.Second)
+		defer upstream.Close()
+
+		gateway := newProxyServer(t, config.RouteConfig{
+			Targets:     []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			Streaming:   true,
+			IdleTimeout: 200 * time.Millisecond,
+		})
+		defer gateway.Close()
+
+		start := time.Now()
+		resp, err := http.Get(gateway.URL + "/api/events")
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var got []string
+		for event := range readEvents(resp) {
+			got = append(got, event)
+		}
+		if strings.Join(got, ",") != "first" {
+			t.Fatalf("空闲超时后应断开，收到事件 %v", got)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("空闲超时未生效，耗时 %s", elapsed)
+		}
+	})
+
+	t.Run("TotalTimeout", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		defer upstream.Close()
+
+		gateway := newProxyServer(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			Timeout: 100 * time.Millisecond,
+		})
+		defer gateway.Close()
+
+		resp, err := http.Get(gateway.URL + "/api/slow")
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("期望状态码 504，获得 %d", resp.StatusCode)
+		}
+	})
+}
+
+// 以 HTTP 服务的形式启动代理
+func newProxyServer(t *testing.T, route config.RouteConfig) *httptest.Server {
+	t.Helper()
+
+	p, err := proxy.NewReverseProxy(route)
+	if err != nil {
+		t.Fatalf("创建代理失败: %v", err)
+	}
+	return httptest.NewServer(p)
+}
+
+// 逐个读取 SSE 事件的 data 字段，响应结束时关闭 channel
+func readEvents(resp *http.Response) <-chan string {
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- data
+			}
+		}
+	}()
+	return events
+}
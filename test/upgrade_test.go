@@ -0,0 +1,242 @@
+//go:build linux
+
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 测试 SIGUSR2 热升级：新进程继承监听 socket，旧进程排空后退出，期间不丢失连接
+func TestBinaryUpgrade(t *testing.T) {
+	if testing.Short() {
+		t.Skip("跳过集成测试")
+	}
+
+	rootDir, err := findProjectRoot()
+	if err != nil {
+		t.Fatalf("无法找到项目根目录: %v", err)
+	}
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "gogate")
+	build := exec.Command("go", "build", "-o", binary, "./cmd/server")
+	build.Dir = rootDir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("编译网关失败: %v\n%s", err, out)
+	}
+
+	// 慢速上游，用于验证旧进程会等待进行中的请求
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		fmt.Fprint(w, "slow")
+	}))
+	defer upstream.Close()
+
+	addr := freeAddr(t)
+	pidFile := filepath.Join(dir, "gogate.pid")
+	configPath := filepath.Join(dir, "config.yaml")
+	configYAML := fmt.Sprintf(`proxy:
+  listen: "%s"
+  pidFile: "%s"
+  routes:
+    "/api/slow":
+      targets:
+        - url: "%s"
+          weight: 1
+jwt:
+  secretKey: "upgrade-test-secret"
+auth:
+  credentials:
+    type: htpasswd
+    file: "%s"
+rateLimit:
+  enable: false
+shutdown:
+  drainTimeout: 5s
+`, addr, pidFile, upstream.URL, filepath.Join(rootDir, "configs", "htpasswd"))
+	if err := os.WriteFile(configPath, []byte(configYAML), 0644); err != nil {
+		t.Fatalf("写入配置失败: %v", err)
+	}
+
+	cmd := exec.Command(binary, "-config", configPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("启动网关失败: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	baseURL := "http://" + addr
+	waitForServer(t, baseURL+"/health", 10)
+
+	oldPID := readPID(t, pidFile)
+	if oldPID != cmd.Process.Pid {
+		t.Fatalf("pid 文件内容 %d 与进程号 %d 不一致", oldPID, cmd.Process.Pid)
+	}
+
+	// 升级期间持续发起新连接，不应出现失败
+	var requests, failures atomic.Int64
+	stop := make(chan struct{})
+	hammerDone := make(chan struct{})
+	go func() {
+		defer close(hammerDone)
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			requests.Add(1)
+			resp, err := client.Get(baseURL + "/health")
+			if err != nil || resp.StatusCode != http.StatusOK {
+				failures.Add(1)
+				t.Logf("请求失败: %v %v", err, resp)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	// 升级前发起一个慢请求，应由旧进程处理完成
+	token := login(t, baseURL)
+	slowResult := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", baseURL+"/api/slow", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			slowResult <- 0
+			return
+		}
+		resp.Body.Close()
+		slowResult <- resp.StatusCode
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatalf("发送 SIGUSR2 失败: %v", err)
+	}
+
+	// 旧进程排空后正常退出
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("旧进程异常退出: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("旧进程未在超时时间内退出")
+	}
+
+	if code := <-slowResult; code != http.StatusOK {
+		t.Fatalf("升级期间进行中的请求应完成，获得状态码 %d", code)
+	}
+
+	newPID := readPID(t, pidFile)
+	if newPID == oldPID {
+		t.Fatal("升级后 pid 文件未更新")
+	}
+	defer syscall.Kill(newPID, syscall.SIGKILL)
+
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	<-hammerDone
+
+	t.Logf("升级期间请求数=%d, 失败=%d", requests.Load(), failures.Load())
+	if failures.Load() != 0 {
+		t.Fatalf("升级期间有 %d 个请求失败", failures.Load())
+	}
+
+	// 新进程收到 SIGTERM 后正常退出并清理 pid 文件
+	if err := syscall.Kill(newPID, syscall.SIGTERM); err != nil {
+		t.Fatalf("发送 SIGTERM 失败: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(pidFile); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("新进程未在超时时间内退出")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// 获取一个空闲的本地地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// 读取 pid 文件
+func readPID(t *testing.T, path string) int {
+	t.Helper()
+
+	var data []byte
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var err error
+		data, err = os.ReadFile(path)
+		if err == nil && len(data) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("读取 pid 文件失败: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("解析 pid 失败: %v", err)
+	}
+	return pid
+}
+
+// 登录获取令牌
+func login(t *testing.T, baseURL string) string {
+	t.Helper()
+
+	resp, err := http.Post(baseURL+"/api/auth/login", "application/json",
+		bytes.NewBufferString(`{"username":"test","password":"test"}`))
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+
+	token, _ := result["token"].(string)
+	if token == "" {
+		t.Fatalf("未获取到有效令牌: %v", result)
+	}
+	return token
+}
@@ -0,0 +1,151 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/proxy"
+)
+
+// 测试上游 TLS 与 mTLS 配置
+func TestUpstreamTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello over tls")
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "upstream-ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", upstream.Certificate().Raw)
+
+	t.Run("UnknownCA", func(t *testing.T) {
+		code := proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+		})
+		if code != http.StatusBadGateway {
+			t.Fatalf("期望状态码 502，获得 %d", code)
+		}
+	})
+
+	t.Run("CustomCA", func(t *testing.T) {
+		code := proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			TLS:     &config.UpstreamTLSConfig{CAFile: caFile},
+		})
+		if code != http.StatusOK {
+			t.Fatalf("期望状态码 200，获得 %d", code)
+		}
+	})
+
+	t.Run("InsecureSkipVerify", func(t *testing.T) {
+		code := proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{
+				URL:    upstream.URL,
+				Weight: 1,
+				TLS:    &config.UpstreamTLSConfig{InsecureSkipVerify: true},
+			}},
+		})
+		if code != http.StatusOK {
+			t.Fatalf("期望状态码 200，获得 %d", code)
+		}
+	})
+
+	t.Run("ServerNameOverride", func(t *testing.T) {
+		// httptest 证书包含 example.com，覆盖 SNI 后校验应通过
+		code := proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			TLS:     &config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"},
+		})
+		if code != http.StatusOK {
+			t.Fatalf("期望状态码 200，获得 %d", code)
+		}
+
+		code = proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			TLS:     &config.UpstreamTLSConfig{CAFile: caFile, ServerName: "unknown.test"},
+		})
+		if code != http.StatusBadGateway {
+			t.Fatalf("期望状态码 502，获得 %d", code)
+		}
+	})
+
+	t.Run("MutualTLS", func(t *testing.T) {
+		caCert, caKey := newTestCA(t)
+		certFile, keyFile := newClientCert(t, dir, caCert, caKey)
+
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(caCert)
+
+		mtlsUpstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "hello %s", r.TLS.PeerCertificates[0].Subject.CommonName)
+		}))
+		mtlsUpstream.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+		mtlsUpstream.StartTLS()
+		defer mtlsUpstream.Close()
+
+		mtlsCAFile := filepath.Join(dir, "mtls-upstream-ca.pem")
+		writePEM(t, mtlsCAFile, "CERTIFICATE", mtlsUpstream.Certificate().Raw)
+
+		// 未提供客户端证书应被拒绝
+		code := proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: mtlsUpstream.URL, Weight: 1}},
+			TLS:     &config.UpstreamTLSConfig{CAFile: mtlsCAFile},
+		})
+		if code != http.StatusBadGateway {
+			t.Fatalf("期望状态码 502，获得 %d", code)
+		}
+
+		code = proxyOnce(t, config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: mtlsUpstream.URL, Weight: 1}},
+			TLS: &config.UpstreamTLSConfig{
+				CAFile:   mtlsCAFile,
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			},
+		})
+		if code != http.StatusOK {
+			t.Fatalf("期望状态码 200，获得 %d", code)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		_, err := proxy.NewReverseProxy(config.RouteConfig{
+			Targets: []config.TargetConfig{{URL: upstream.URL, Weight: 1}},
+			TLS:     &config.UpstreamTLSConfig{CertFile: filepath.Join(dir, "missing.pem")},
+		})
+		if err == nil {
+			t.Fatal("期望只配置证书而缺少私钥时返回错误")
+		}
+	})
+}
+
+// 通过代理发送一次请求并返回状态码
+func proxyOnce(t *testing.T, route config.RouteConfig) int {
+	t.Helper()
+
+	code, _ := proxyRequest(t, route)
+	return code
+}
+
+// 通过代理发送一次请求并返回状态码和响应体
+func proxyRequest(t *testing.T, route config.RouteConfig) (int, string) {
+	t.Helper()
+
+	p, err := proxy.NewReverseProxy(route)
+	if err != nil {
+		t.Fatalf("创建代理失败: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/api/test", nil))
+	return rec.Code, rec.Body.String()
+}
@@ -0,0 +1,406 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ilukemagic/gogate/internal/config"
+	"github.com/ilukemagic/gogate/internal/handler"
+	"github.com/ilukemagic/gogate/internal/middleware"
+)
+
+// 测试 WebSocket 代理：握手鉴权、连接数限制、空闲超时、关闭帧、非法帧与最少连接均衡
+func TestWebSocketProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	echo := newWSEchoServer("echo")
+	defer echo.Close()
+	serverA := newWSEchoServer("a")
+	defer serverA.Close()
+	serverB := newWSEchoServer("b")
+	defer serverB.Close()
+
+	jwtMiddleware, err := middleware.NewJWTMiddleware(config.JWTConfig{SecretKey: "ws-test-secret"})
+	if err != nil {
+		t.Fatalf("创建 JWT 中间件失败: %v", err)
+	}
+	proxyHandler, err := handler.NewProxyHandler(map[string]config.RouteConfig{
+		"/api/ws": {
+			Targets: []config.TargetConfig{{URL: echo.URL, Weight: 1}},
+		},
+		"/api/limited": {
+			Targets:   []config.TargetConfig{{URL: echo.URL, Weight: 1}},
+			WebSocket: config.WebSocketConfig{MaxConnections: 1},
+		},
+		"/api/idle": {
+			Targets:   []config.TargetConfig{{URL: echo.URL, Weight: 1}},
+			WebSocket: config.WebSocketConfig{IdleTimeout: 200 * time.Millisecond},
+		},
+		"/api/balanced": {
+			Targets: []config.TargetConfig{
+				{URL: serverA.URL, Weight: 1},
+				{URL: serverB.URL, Weight: 1},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建代理处理器失败: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		jwtMiddleware.Handle()(c)
+		if !c.IsAborted() {
+			proxyHandler.Handle(c)
+		}
+		c.Abort()
+	})
+	gateway := httptest.NewServer(r)
+	defer gateway.Close()
+	addr := strings.TrimPrefix(gateway.URL, "http://")
+
+	token, err := jwtMiddleware.GenerateToken("1", "ws")
+	if err != nil {
+		t.Fatalf("生成令牌失败: %v", err)
+	}
+
+	t.Run("TokenInQuery", func(t *testing.T) {
+		conn, br, resp := wsDial(t, addr, "/api/ws?room=1&access_token="+token, nil)
+		defer conn.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("期望状态码 101，获得 %d", resp.StatusCode)
+		}
+
+		wsWriteText(t, conn, "hello")
+		if _, msg := wsReadFrame(t, br); msg != "echo: hello" {
+			t.Fatalf("期望回显 %q，获得 %q", "echo: hello", msg)
+		}
+
+		// token 不应转发给上游
+		if query := echo.lastQuery.Load().(string); query != "room=1" {
+			t.Fatalf("期望上游查询参数为 room=1，获得 %q", query)
+		}
+	})
+
+	t.Run("TokenInProtocol", func(t *testing.T) {
+		header := http.Header{"Sec-Websocket-Protocol": {"chat, bearer." + token}}
+		conn, br, resp := wsDial(t, addr, "/api/ws", header)
+		defer conn.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("期望状态码 101，获得 %d", resp.StatusCode)
+		}
+
+		wsWriteText(t, conn, "hi")
+		if _, msg := wsReadFrame(t, br); msg != "echo: hi" {
+			t.Fatalf("期望回显 %q，获得 %q", "echo: hi", msg)
+		}
+
+		if protocol := echo.lastProtocol.Load().(string); protocol != "chat" {
+			t.Fatalf("期望上游子协议为 chat，获得 %q", protocol)
+		}
+	})
+
+	t.Run("TokenOnlyProtocol", func(t *testing.T) {
+		// 只提供携带 token 的协议时，网关在 101 响应中回显该协议，否则浏览器会使握手失败
+		header := http.Header{"Sec-Websocket-Protocol": {"bearer." + token}}
+		conn, br, resp := wsDial(t, addr, "/api/ws", header)
+		defer conn.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("期望状态码 101，获得 %d", resp.StatusCode)
+		}
+		if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "bearer."+token {
+			t.Fatalf("期望 101 响应回显子协议 %q，获得 %q", "bearer."+token, protocol)
+		}
+
+		wsWriteText(t, conn, "hi")
+		if _, msg := wsReadFrame(t, br); msg != "echo: hi" {
+			t.Fatalf("期望回显 %q，获得 %q", "echo: hi", msg)
+		}
+
+		if protocol := echo.lastProtocol.Load().(string); protocol != "" {
+			t.Fatalf("期望上游没有收到子协议，获得 %q", protocol)
+		}
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		conn, _, resp := wsDial(t, addr, "/api/ws", nil)
+		defer conn.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("期望状态码 401，获得 %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("MaxConnections", func(t *testing.T) {
+		first, _, resp := wsDial(t, addr, "/api/limited?access_token="+token, nil)
+		defer first.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("期望状态码 101，获得 %d", resp.StatusCode)
+		}
+
+		second, _, resp := wsDial(t, addr, "/api/limited?access_token="+token, nil)
+		defer second.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("超过最大连接数时期望状态码 503，获得 %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		conn, br, _ := wsDial(t, addr, "/api/idle?access_token="+token, nil)
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		opcode, payload := wsReadFrame(t, br)
+		if opcode != 0x8 || closeCode(payload) != 1001 {
+			t.Fatalf("期望收到 1001 关闭帧，获得 opcode=%d code=%d", opcode, closeCode(payload))
+		}
+	})
+
+	t.Run("InvalidFrameLength", func(t *testing.T) {
+		conn, br, _ := wsDial(t, addr, "/api/ws?access_token="+token, nil)
+		defer conn.Close()
+
+		// 64 位负载长度的最高位为 1
+		header := []byte{0x82, 0x80 | 127, 0x80, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+		if _, err := conn.Write(header); err != nil {
+			t.Fatalf("发送帧失败: %v", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		opcode, payload := wsReadFrame(t, br)
+		if opcode != 0x8 || closeCode(payload) != 1002 {
+			t.Fatalf("期望收到 1002 关闭帧，获得 opcode=%d code=%d", opcode, closeCode(payload))
+		}
+	})
+
+	t.Run("LeastConnections", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			conn, _, resp := wsDial(t, addr, "/api/balanced?access_token="+token, nil)
+			defer conn.Close()
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("期望状态码 101，获得 %d", resp.StatusCode)
+			}
+		}
+
+		waitFor(t, func() bool { return serverA.active.Load()+serverB.active.Load() == 4 })
+		if a, b := serverA.active.Load(), serverB.active.Load(); a != 2 || b != 2 {
+			t.Fatalf("期望长连接均匀分布 2/2，获得 %d/%d", a, b)
+		}
+	})
+
+	t.Run("CloseOnShutdown", func(t *testing.T) {
+		conn, br, _ := wsDial(t, addr, "/api/ws?access_token="+token, nil)
+		defer conn.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			done <- proxyHandler.CloseWebSockets(ctx)
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		opcode, payload := wsReadFrame(t, br)
+		if opcode != 0x8 || closeCode(payload) != 1001 {
+			t.Fatalf("期望收到 1001 关闭帧，获得 opcode=%d code=%d", opcode, closeCode(payload))
+		}
+		wsWriteFrame(t, conn, 0x8, []byte(payload))
+
+		if err := <-done; err != nil {
+			t.Fatalf("关闭 WebSocket 连接失败: %v", err)
+		}
+		if n := proxyHandler.ActiveWebSockets(); n != 0 {
+			t.Fatalf("期望没有活跃连接，获得 %d", n)
+		}
+
+		// 关闭后不再接受新的连接
+		rejected, _, resp := wsDial(t, addr, "/api/ws?access_token="+token, nil)
+		defer rejected.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("关闭后期望状态码 503，获得 %d", resp.StatusCode)
+		}
+	})
+}
+
+// 模拟 WebSocket 回显服务
+type wsEchoServer struct {
+	*httptest.Server
+	active       atomic.Int32
+	lastQuery    atomic.Value
+	lastProtocol atomic.Value
+}
+
+func newWSEchoServer(name string) *wsEchoServer {
+	s := &wsEchoServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lastQuery.Store(r.URL.RawQuery)
+		s.lastProtocol.Store(r.Header.Get("Sec-WebSocket-Protocol"))
+
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		s.active.Add(1)
+		defer s.active.Add(-1)
+
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		brw.Flush()
+
+		for {
+			opcode, payload, err := readWSFrame(brw.Reader)
+			if err != nil {
+				return
+			}
+			if opcode == 0x8 {
+				conn.Write(encodeWSFrame(0x8, payload, false))
+				return
+			}
+			conn.Write(encodeWSFrame(opcode, []byte(name+": "+string(payload)), false))
+		}
+	}))
+	return s
+}
+
+// 发起 WebSocket 握手
+func wsDial(t *testing.T, addr, path string, header http.Header) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", "http://"+addr+path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString([]byte("gogate-test-key!")))
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("发送握手失败: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("读取握手响应失败: %v", err)
+	}
+
+	return conn, br, resp
+}
+
+// 发送文本帧
+func wsWriteText(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	wsWriteFrame(t, conn, 0x1, []byte(msg))
+}
+
+// 发送客户端帧 (需要掩码)
+func wsWriteFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+	if _, err := conn.Write(encodeWSFrame(opcode, payload, true)); err != nil {
+		t.Fatalf("发送帧失败: %v", err)
+	}
+}
+
+// 读取一帧
+func wsReadFrame(t *testing.T, br *bufio.Reader) (byte, string) {
+	t.Helper()
+
+	opcode, payload, err := readWSFrame(br)
+	if err != nil {
+		t.Fatalf("读取帧失败: %v", err)
+	}
+	return opcode, string(payload)
+}
+
+// 编码一帧 (仅支持 125 字节以内的负载)
+func encodeWSFrame(opcode byte, payload []byte, mask bool) []byte {
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	if !mask {
+		return append(frame, payload...)
+	}
+
+	key := make([]byte, 4)
+	rand.Read(key)
+	frame[1] |= 0x80
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
+
+// 解码一帧 (仅支持 125 字节以内的负载)
+func readWSFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	var key []byte
+	if header[1]&0x80 != 0 {
+		key = make([]byte, 4)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload := make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if key != nil {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+
+	return header[0] & 0x0f, payload, nil
+}
+
+// 计算 Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// 解析关闭帧状态码
+func closeCode(payload string) int {
+	if len(payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16([]byte(payload[:2])))
+}
+
+// 等待条件成立
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待条件超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}